@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Output kinds accepted by the -emit flag.
+const (
+	emitJS            = "js"
+	emitTSDeclaration = "d.ts"
+	emitTS            = "ts"
+	emitJSON          = "json"
+	emitDepfile       = "d"
+)
+
+// runBatch transforms every input stylesheet matched by the command line
+// arguments, mirroring the directory structure under the output directory.
+// Errors are reported for every failing file. It returns the exit code.
+func runBatch(o *options, stderr io.Writer) int {
+	jobs, err := o.batchJobs()
+	if err != nil {
+		fmt.Fprintf(stderr, "fatal: %s\n", err)
+		return 1
+	}
+	errs := runJobs(jobs, o.parallelism)
+	failed := 0
+	for i, err := range errs {
+		jobs[i].writeWarnings(stderr)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %s: %s\n", jobs[i].InputPath, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(stderr, "%d of %d files failed\n", failed, len(jobs))
+		return 1
+	}
+	return 0
+}
+
+// runJobs runs the given jobs on a pool of at most n workers, and returns
+// the error for each job, in the same order as the jobs. If n is not positive,
+// the pool is sized to GOMAXPROCS.
+func runJobs(jobs []*job, n int) []error {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	errs := make([]error, len(jobs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = jobs[i].run()
+			}
+		}()
+	}
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return errs
+}
+
+// batchJobs returns the jobs for the inputs matched by the positional
+// arguments.
+func (o *options) batchJobs() ([]*job, error) {
+	inputs, err := expandGlobs(o.flags.Args(), o.outputDir)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*job, 0, len(inputs))
+	for _, in := range inputs {
+		o, err := o.forFile(in)
+		if err != nil {
+			return nil, err
+		}
+		emit, err := parseEmit(o.emit)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(o.rootDir, in)
+		if err != nil {
+			return nil, err
+		}
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("input %q is not under the root directory %q (`-root` flag)", in, o.rootDir)
+		}
+		out := filepath.Join(o.outputDir, rel)
+		j := &job{
+			InputPath:    in,
+			OutputPath:   out,
+			JSModuleName: path.Join(o.jsModulePrefix, filepath.ToSlash(rel)),
+		}
+		if emit[emitJS] {
+			j.JSPath = out + ".js"
+		}
+		if emit[emitTSDeclaration] {
+			j.TSDeclarationPath = out + ".d.ts"
+		}
+		if emit[emitTS] {
+			j.TSPath = out + ".ts"
+		}
+		if emit[emitJSON] {
+			j.JSONPath = out + ".json"
+		}
+		if emit[emitDepfile] {
+			j.DepfilePath = out + ".d"
+		}
+		if err := o.configureJob(j); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+func parseEmit(value string) (map[string]bool, error) {
+	emit := map[string]bool{}
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.TrimSpace(kind)
+		switch kind {
+		case "":
+			continue
+		case emitJS, emitTSDeclaration, emitTS, emitJSON, emitDepfile:
+			emit[kind] = true
+		default:
+			return nil, fmt.Errorf("unknown output kind %q (`-emit` flag)", kind)
+		}
+	}
+	return emit, nil
+}
+
+// expandGlobs returns the files matched by the given patterns, in order and
+// without duplicates. Patterns without glob syntax are returned as-is.
+// In addition to the syntax supported by path.Match, a "**" path segment
+// matches zero or more directories. Files under skipDir are never matched.
+func expandGlobs(patterns []string, skipDir string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if hasGlobMeta(pattern) {
+			var err error
+			matches, err = glob(pattern, skipDir)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", pattern)
+			}
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				out = append(out, m)
+			}
+		}
+	}
+	return out, nil
+}
+
+func glob(pattern, skipDir string) ([]string, error) {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	i := 0
+	for i < len(segments) && !hasGlobMeta(segments[i]) {
+		i++
+	}
+	base := strings.Join(segments[:i], "/")
+	if base == "" {
+		base = "."
+		if strings.HasPrefix(pattern, "/") {
+			base = "/"
+		}
+	}
+	rest := segments[i:]
+	for _, s := range rest {
+		if _, err := path.Match(s, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+	}
+	skipDir = filepath.Clean(skipDir)
+
+	var matches []string
+	err := filepath.WalkDir(filepath.FromSlash(base), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == skipDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		if matchSegments(rest, strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
+
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
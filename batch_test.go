@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandGlobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{
+		"src/a.module.css",
+		"src/a.css",
+		"src/x/b.module.css",
+		"src/x/y/c.module.css",
+		"dist/src/a.module.css",
+	} {
+		p = filepath.Join(dir, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, test := range []struct {
+		patterns []string
+		expected []string
+	}{
+		{[]string{"src/**/*.module.css"}, []string{"src/a.module.css", "src/x/b.module.css", "src/x/y/c.module.css"}},
+		{[]string{"**/*.module.css"}, []string{"src/a.module.css", "src/x/b.module.css", "src/x/y/c.module.css"}},
+		{[]string{"src/*/*.module.css"}, []string{"src/x/b.module.css"}},
+		{[]string{"src/a.css", "src/*.css"}, []string{"src/a.css", "src/a.module.css"}},
+	} {
+		actual, err := expandGlobs(test.patterns, "dist")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(test.expected, actual) {
+			t.Fatalf("expandGlobs(%q): expected %q, got %q", test.patterns, test.expected, actual)
+		}
+	}
+
+	if _, err := expandGlobs([]string{"src/**/*.scss"}, "dist"); err == nil {
+		t.Fatal("expected error for pattern without matches")
+	}
+}
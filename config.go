@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultConfigPath is the config file which is read from the working
+// directory if the -config flag is not set.
+const defaultConfigPath = "cssbuild.json"
+
+// perFileFlags are the flags which may be set by config overrides, since
+// they can differ between the files of a batch.
+var perFileFlags = map[string]bool{
+	"camel_case_js_keys":       true,
+	"stable_suffix":            true,
+	"style":                    true,
+	"indent":                   true,
+	"minify":                   true,
+	"preserve_comments":        true,
+	"strict":                   true,
+	"scope_custom_properties":  true,
+	"global_custom_properties": true,
+	"js_module_prefix":         true,
+	"emit":                     true,
+}
+
+// config is a parsed config file. Its keys are flag names, and its values are
+// the defaults for those flags.
+type config struct {
+	// path is the path of the config file.
+	path      string
+	values    map[string]string
+	overrides []*configOverride
+}
+
+// configOverride holds flag values for the input files matching any of the
+// given glob patterns.
+type configOverride struct {
+	files  []string
+	values map[string]string
+}
+
+func (c *configOverride) matches(path string) bool {
+	name := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	for _, pattern := range c.files {
+		if matchSegments(strings.Split(pattern, "/"), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig reads the config file given by the -config flag, or the default
+// config file if it exists, and applies it to any flags which were not set on
+// the command line.
+func (o *options) loadConfig() error {
+	path := o.configPath
+	if path == "" {
+		if _, err := os.Stat(defaultConfigPath); err != nil {
+			return nil
+		}
+		path = defaultConfigPath
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c, err := parseConfig(b, o)
+	if err != nil {
+		return fmt.Errorf("invalid config file %q: %s", path, err)
+	}
+	c.path = path
+	o.config = c
+	return o.applyConfig(c.values)
+}
+
+// forFile returns the options for the given input file, which differ from o
+// if any config overrides match the file.
+func (o *options) forFile(path string) (*options, error) {
+	if o.config == nil {
+		return o, nil
+	}
+	var matched []*configOverride
+	for _, ov := range o.config.overrides {
+		if ov.matches(path) {
+			matched = append(matched, ov)
+		}
+	}
+	if len(matched) == 0 {
+		return o, nil
+	}
+	fo := newOptions(o.flags.Output())
+	if err := fo.parse(o.args); err != nil {
+		return nil, err
+	}
+	fo.config = o.config
+	if err := fo.applyConfig(o.config.values); err != nil {
+		return nil, err
+	}
+	for _, ov := range matched {
+		if err := fo.applyConfig(ov.values); err != nil {
+			return nil, err
+		}
+	}
+	if err := fo.validateFile(); err != nil {
+		return nil, fmt.Errorf("invalid options for %q after config overrides: %s", path, err)
+	}
+	return fo, nil
+}
+
+// applyConfig sets the given flag values, except for flags which were set on
+// the command line.
+func (o *options) applyConfig(values map[string]string) error {
+	for name, value := range values {
+		if o.setOnCommandLine[name] {
+			continue
+		}
+		if err := o.flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %q in config file: %s", value, name, err)
+		}
+	}
+	return nil
+}
+
+func parseConfig(b []byte, o *options) (*config, error) {
+	var raw map[string]json.RawMessage
+	if err := unmarshalJSON(b, &raw); err != nil {
+		return nil, err
+	}
+	c := &config{}
+	var err error
+	if value, ok := raw["overrides"]; ok {
+		var overrides []map[string]json.RawMessage
+		if err := unmarshalJSON(value, &overrides); err != nil {
+			return nil, fmt.Errorf("\"overrides\": %s", err)
+		}
+		for i, rawOverride := range overrides {
+			ov := &configOverride{}
+			files, ok := rawOverride["files"]
+			if !ok {
+				return nil, fmt.Errorf("\"overrides\"[%d]: missing \"files\" key", i)
+			}
+			delete(rawOverride, "files")
+			if ov.files, err = configStrings(files); err != nil {
+				return nil, fmt.Errorf("\"overrides\"[%d]: \"files\": %s", i, err)
+			}
+			if ov.values, err = configValues(rawOverride, o, true); err != nil {
+				return nil, fmt.Errorf("\"overrides\"[%d]: %s", i, err)
+			}
+			c.overrides = append(c.overrides, ov)
+		}
+	}
+	delete(raw, "overrides")
+	if c.values, err = configValues(raw, o, false); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// configValues converts the given config values to flag values, and returns
+// an error for keys which are not flags.
+func configValues(raw map[string]json.RawMessage, o *options, perFile bool) (map[string]string, error) {
+	values := map[string]string{}
+	for key, value := range raw {
+		if o.flags.Lookup(key) == nil || key == "config" || key == "persistent_worker" {
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+		if perFile && !perFileFlags[key] {
+			return nil, fmt.Errorf("%q cannot be overridden per file", key)
+		}
+		var s string
+		var v interface{}
+		if err := unmarshalJSON(value, &v); err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case string:
+			s = v
+		case bool, json.Number:
+			s = fmt.Sprint(v)
+		case []interface{}:
+			list, err := configStrings(value)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %s", key, err)
+			}
+			s = strings.Join(list, ",")
+		default:
+			return nil, fmt.Errorf("%q: unsupported value %s", key, string(value))
+		}
+		values[key] = s
+	}
+	return values, nil
+}
+
+// configStrings parses a string, or a list of strings.
+func configStrings(raw json.RawMessage) ([]string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("expected a string or a list of strings")
+	}
+	return list, nil
+}
+
+func unmarshalJSON(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cssbuild.json")
+	err := os.WriteFile(path, []byte(`{
+		"camel_case_js_keys": true,
+		"emit": ["js", "d.ts", "json"],
+		"js_module_prefix": "app",
+		"overrides": [
+			{"files": "src/legacy/**", "camel_case_js_keys": false, "emit": "js"},
+			{"files": ["src/legacy/new/*.css"], "emit": "ts"}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := newOptions(io.Discard)
+	if err := o.parse([]string{"-config", path, "-js_module_prefix", "web", "-out_dir", "dist", "src/**/*.css"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path            string
+		camelCaseJSKeys bool
+		emit            string
+	}{
+		{"src/a.css", true, "js,d.ts,json"},
+		{"src/legacy/b.css", false, "js"},
+		{"src/legacy/new/c.css", false, "ts"},
+	} {
+		fo, err := o.forFile(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fo.camelCaseJSKeys != test.camelCaseJSKeys || fo.emit != test.emit {
+			t.Fatalf("%s: expected camel_case_js_keys=%t and emit=%q, got %t and %q", test.path, test.camelCaseJSKeys, test.emit, fo.camelCaseJSKeys, fo.emit)
+		}
+		// Flags set on the command line take precedence over the config file.
+		if fo.jsModulePrefix != "web" {
+			t.Fatalf("%s: expected js_module_prefix from command line, got %q", test.path, fo.jsModulePrefix)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for _, test := range []struct {
+		config string
+		err    string
+	}{
+		{`{"camel_case_keys": true}`, `unknown key "camel_case_keys"`},
+		{`{"overrides": [{"emit": "js"}]}`, `missing "files" key`},
+		{`{"overrides": [{"files": "*.css", "out_dir": "dist"}]}`, `"out_dir" cannot be overridden per file`},
+		{`{"j": "many"}`, `invalid value "many" for "j"`},
+	} {
+		path := filepath.Join(t.TempDir(), "cssbuild.json")
+		if err := os.WriteFile(path, []byte(test.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		o := newOptions(io.Discard)
+		if err := o.parse([]string{"-config", path}); err != nil {
+			t.Fatal(err)
+		}
+		if err := o.loadConfig(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("%s: expected error containing %q, got %v", test.config, test.err, err)
+		}
+	}
+}
+
+func TestConfigOverridesAreValidated(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cssbuild.json")
+	err := os.WriteFile(path, []byte(`{
+		"stable_suffix": true,
+		"overrides": [{"files": "src/legacy/**", "stable_suffix": false}]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newOptions(io.Discard)
+	if err := o.parse([]string{"-config", path, "-check", "-out_dir", "dist", "src/**/*.css"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.forFile("src/a.css"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.forFile("src/legacy/b.css")
+	if err == nil || !strings.Contains(err.Error(), "`-check` flag requires `-stable_suffix` flag") {
+		t.Fatalf("expected -check validation error, got %v", err)
+	}
+}
+
+func TestConfigIsDependency(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cssbuild.json")
+	if err := os.WriteFile(path, []byte(`{"camel_case_js_keys": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in := filepath.Join(dir, "a.module.css")
+	if err := os.WriteFile(in, []byte(".foo { color: red; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o := newOptions(io.Discard)
+	err := o.parse([]string{"-config", path, "-in", in, "-out", filepath.Join(dir, "a.css"), "-js_module_name", "a", "-depfile", filepath.Join(dir, "a.css.d")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	j, err := o.singleJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := j.transform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	depfile := outputs[len(outputs)-1].Data.String()
+	if !strings.Contains(depfile, escapeDepfilePath(path)) {
+		t.Fatalf("expected config file in depfile, got %q", depfile)
+	}
+}
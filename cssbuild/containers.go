@@ -0,0 +1,93 @@
+package cssbuild
+
+import (
+	"strings"
+
+	"github.com/bduffany/cssbuild/cssbuild/css"
+)
+
+// containerQueryKeywords are the keywords which may start a container query
+// instead of a container name.
+var containerQueryKeywords = map[string]bool{
+	"not": true,
+	"and": true,
+	"or":  true,
+}
+
+// isContainerRule returns whether the at-rule with the given name is a
+// container query.
+func isContainerRule(name string) bool {
+	return name == "@container"
+}
+
+// scopeContainerName returns the given container name, suffixed and recorded
+// in the mappings if the scope is local.
+func scopeContainerName(val css.Token, scope scopeType, opts *TransformOpts, js *jsMappings) css.Token {
+	if scope == global {
+		return val
+	}
+	js.ContainerNames[string(val.Data)] = struct{}{}
+	return withSuffix(val, opts.Suffix)
+}
+
+// transformContainerProperty suffixes the container names in the value of a
+// container-name property, or of a container shorthand property, whose names
+// come before the "/". A name wrapped in global() or local() is scoped
+// accordingly regardless of the scope of the rule.
+func transformContainerProperty(values []css.Token, scope scopeType, opts *TransformOpts, js *jsMappings) []css.Token {
+	out := make([]css.Token, 0, len(values))
+	for i := 0; i < len(values); i++ {
+		val := values[i]
+		if isDelim(val, '/') {
+			// The container type.
+			return append(out, values[i:]...)
+		}
+		if name, end, nameScope, ok := scopedName(values, i); ok && values[name].TokenType == css.IdentToken {
+			out = append(out, scopeContainerName(values[name], nameScope, opts, js))
+			i = end
+			continue
+		}
+		if val.TokenType == css.IdentToken {
+			ident := strings.ToLower(string(val.Data))
+			if ident != "none" && !cssWideKeywords[ident] {
+				val = scopeContainerName(val, scope, opts, js)
+			}
+		}
+		out = append(out, val)
+	}
+	return out
+}
+
+// transformContainerPrelude suffixes the container names in the prelude of a
+// @container rule, which is a comma-separated list of queries which may each
+// start with a name, as in "@container sidebar (min-width: 400px)".
+func transformContainerPrelude(values []css.Token, opts *TransformOpts, js *jsMappings) []css.Token {
+	out := make([]css.Token, 0, len(values))
+	start := true
+	depth := 0
+	for i := 0; i < len(values); i++ {
+		val := values[i]
+		if val.TokenType == css.WhitespaceToken || val.TokenType == css.CommentToken {
+			out = append(out, val)
+			continue
+		}
+		if start {
+			start = false
+			if name, end, nameScope, ok := scopedName(values, i); ok && values[name].TokenType == css.IdentToken {
+				out = append(out, scopeContainerName(values[name], nameScope, opts, js))
+				i = end
+				continue
+			}
+			if val.TokenType == css.IdentToken && !containerQueryKeywords[strings.ToLower(string(val.Data))] {
+				out = append(out, scopeContainerName(val, local, opts, js))
+				continue
+			}
+		}
+		depth += nestingDelta(val)
+		if depth == 0 && val.TokenType == css.CommaToken {
+			start = true
+		}
+		out = append(out, val)
+	}
+	return out
+}
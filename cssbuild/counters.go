@@ -0,0 +1,195 @@
+package cssbuild
+
+import (
+	"strings"
+
+	"github.com/bduffany/cssbuild/cssbuild/css"
+)
+
+// isCounterProperty returns whether the property with the given name lists
+// counter names, each optionally followed by an integer.
+func isCounterProperty(name string) bool {
+	return name == "counter-reset" || name == "counter-increment" || name == "counter-set"
+}
+
+// isCounterStyleProperty returns whether the property with the given name may
+// reference a counter style by name.
+func isCounterStyleProperty(name string) bool {
+	return name == "list-style" || name == "list-style-type"
+}
+
+// isCounterStyleRule returns whether the at-rule with the given name defines
+// a counter style.
+func isCounterStyleRule(name string) bool {
+	return name == "@counter-style"
+}
+
+// counterStyleName returns the index of the name in the prelude of a
+// @counter-style rule, or -1 if there is none, along with the index of its
+// last token, which differ if the name is wrapped in global() or local(),
+// and the scope given to the name.
+func counterStyleName(values []css.Token) (name, end int, scope scopeType) {
+	i := skipWhitespace(values, 0)
+	if i == len(values) {
+		return -1, -1, local
+	}
+	if name, end, scope, ok := scopedName(values, i); ok && values[name].TokenType == css.IdentToken {
+		return name, end, scope
+	}
+	if values[i].TokenType == css.IdentToken {
+		return i, i, local
+	}
+	return -1, -1, local
+}
+
+// isCounterName returns whether the identifier may name a counter defined by
+// the module, as opposed to a keyword or the built-in list-item counter.
+func isCounterName(val css.Token) bool {
+	ident := strings.ToLower(string(val.Data))
+	return ident != "none" && ident != "list-item" && !cssWideKeywords[ident]
+}
+
+// counterRefs scopes the counter names and counter style names referenced by
+// declarations.
+type counterRefs struct {
+	// styles is the set of counter styles defined by locally scoped
+	// @counter-style rules in the module.
+	styles map[string]bool
+	suffix []byte
+	js     *jsMappings
+}
+
+// counter returns the given counter name, suffixed and recorded in the
+// mappings if the scope is local.
+func (r *counterRefs) counter(val css.Token, scope scopeType) css.Token {
+	if scope == global {
+		return val
+	}
+	r.js.CounterNames[string(val.Data)] = struct{}{}
+	return withSuffix(val, r.suffix)
+}
+
+// declareStyle returns the name of a counter style defined by a
+// @counter-style rule, suffixed and recorded in the mappings if the scope is
+// local.
+func (r *counterRefs) declareStyle(val css.Token, scope scopeType) css.Token {
+	if scope == global {
+		return val
+	}
+	r.js.CounterStyles[string(val.Data)] = struct{}{}
+	return withSuffix(val, r.suffix)
+}
+
+// style returns the given counter style reference, suffixed if it refers to a
+// locally defined counter style, or if it is wrapped in local(). Other names
+// are left as they are, since they may be predefined styles like "decimal".
+func (r *counterRefs) style(val css.Token, scope scopeType, explicit bool) css.Token {
+	if scope == global {
+		return val
+	}
+	if explicit || r.styles[string(val.Data)] {
+		return withSuffix(val, r.suffix)
+	}
+	return val
+}
+
+// transformCounterProperty suffixes the counter names in the value of a
+// counter-reset, counter-increment or counter-set property, including those
+// in reversed() functions. A name wrapped in global() or local() is scoped
+// accordingly regardless of the scope of the rule.
+func transformCounterProperty(values []css.Token, scope scopeType, r *counterRefs) []css.Token {
+	out := make([]css.Token, 0, len(values))
+	depth := 0
+	inReversed := false
+	for i := 0; i < len(values); i++ {
+		val := values[i]
+		if depth == 0 || inReversed && depth == 1 {
+			if name, end, nameScope, ok := scopedName(values, i); ok && values[name].TokenType == css.IdentToken {
+				out = append(out, r.counter(values[name], nameScope))
+				i = end
+				continue
+			}
+			if val.TokenType == css.IdentToken && isCounterName(val) {
+				val = r.counter(val, scope)
+			}
+		}
+		if depth == 0 && val.TokenType == css.FunctionToken {
+			inReversed = strings.ToLower(string(val.Data)) == "reversed("
+		}
+		depth += nestingDelta(val)
+		out = append(out, val)
+	}
+	return out
+}
+
+// transformCounterStyleReferences suffixes the references to locally defined
+// counter styles in the value of a list-style or list-style-type property, or
+// of the system and fallback descriptors of a @counter-style rule.
+func transformCounterStyleReferences(values []css.Token, scope scopeType, r *counterRefs) []css.Token {
+	out := make([]css.Token, 0, len(values))
+	depth := 0
+	for i := 0; i < len(values); i++ {
+		val := values[i]
+		if depth == 0 {
+			if name, end, nameScope, ok := scopedName(values, i); ok && values[name].TokenType == css.IdentToken {
+				out = append(out, r.style(values[name], nameScope, nameScope == local))
+				i = end
+				continue
+			}
+			if val.TokenType == css.IdentToken {
+				val = r.style(val, scope, false)
+			}
+		}
+		depth += nestingDelta(val)
+		out = append(out, val)
+	}
+	return out
+}
+
+// transformCounterFunctions suffixes the counter names and counter style
+// references in the counter() and counters() functions in the given values,
+// such as in the content property. The counter name is the first argument,
+// and the counter style is the last one.
+func transformCounterFunctions(values []css.Token, scope scopeType, r *counterRefs) []css.Token {
+	out := make([]css.Token, 0, len(values))
+	depth := 0
+	// fnDepth is the depth inside the innermost counter() or counters()
+	// function, or 0 if not in one, and arg is the index of the current
+	// argument within it.
+	fnDepth, arg, styleArg := 0, 0, 0
+	for i := 0; i < len(values); i++ {
+		val := values[i]
+		if fnDepth > 0 && depth == fnDepth && (arg == 0 || arg == styleArg) {
+			if name, end, nameScope, ok := scopedName(values, i); ok && values[name].TokenType == css.IdentToken {
+				if arg == 0 {
+					out = append(out, r.counter(values[name], nameScope))
+				} else {
+					out = append(out, r.style(values[name], nameScope, nameScope == local))
+				}
+				i = end
+				continue
+			}
+			if val.TokenType == css.IdentToken {
+				if arg != 0 {
+					val = r.style(val, scope, false)
+				} else if isCounterName(val) {
+					val = r.counter(val, scope)
+				}
+			}
+		}
+		switch fn := strings.ToLower(string(val.Data)); {
+		case val.TokenType == css.FunctionToken && (fn == "counter(" || fn == "counters("):
+			fnDepth, arg, styleArg = depth+1, 0, 1
+			if fn == "counters(" {
+				styleArg = 2
+			}
+		case val.TokenType == css.CommaToken && depth == fnDepth:
+			arg++
+		case val.TokenType == css.RightParenthesisToken && depth == fnDepth:
+			fnDepth = 0
+		}
+		depth += nestingDelta(val)
+		out = append(out, val)
+	}
+	return out
+}
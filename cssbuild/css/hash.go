@@ -10,12 +10,15 @@ type Hash uint32
 
 // Unique hash definitions to be used instead of strings
 const (
-	Document  Hash = 0x8    // document
-	Font_Face Hash = 0x809  // font-face
-	Keyframes Hash = 0x1109 // keyframes
-	Media     Hash = 0x2105 // media
-	Page      Hash = 0x2604 // page
-	Supports  Hash = 0x1908 // supports
+	Container     Hash = 0xd09  // container
+	Counter_Style Hash = 0xd    // counter-style
+	Document      Hash = 0x2808 // document
+	Font_Face     Hash = 0x1609 // font-face
+	Keyframes     Hash = 0x1f09 // keyframes
+	Media         Hash = 0x4005 // media
+	Page          Hash = 0x4504 // page
+	Property      Hash = 0x3008 // property
+	Supports      Hash = 0x3808 // supports
 )
 
 // String returns the hash' name.
@@ -62,14 +65,17 @@ NEXT:
 }
 
 const _Hash_hash0 = 0x9acb0442
-const _Hash_maxLen = 9
-const _Hash_text = "documentfont-facekeyframesupportsmediapage"
+const _Hash_maxLen = 13
+const _Hash_text = "counter-stylecontainerfont-facekeyframesdocumentpropertysupportsmediapage"
 
-var _Hash_table = [1 << 3]Hash{
-	0x1: 0x2604, // page
-	0x2: 0x2105, // media
-	0x3: 0x809,  // font-face
-	0x5: 0x1109, // keyframes
-	0x6: 0x1908, // supports
-	0x7: 0x8,    // document
+var _Hash_table = [1 << 4]Hash{
+	0x1: 0xd09,  // container
+	0x2: 0x4005, // media
+	0x3: 0x1609, // font-face
+	0x6: 0x3808, // supports
+	0x7: 0x3008, // property
+	0x9: 0x4504, // page
+	0xb: 0x1f09, // keyframes
+	0xe: 0xd,    // counter-style
+	0xf: 0x2808, // document
 }
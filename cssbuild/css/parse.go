@@ -69,6 +69,12 @@ type State func(*Parser) GrammarType
 type Token struct {
 	TokenType
 	Data []byte
+
+	// Offset is the byte offset of the token in the input, or -1 if the
+	// token was synthesized by the parser. Whitespace tokens, which the parser
+	// normalizes to a single space, have the offset of the first whitespace
+	// character which they replace.
+	Offset int
 }
 
 func (t Token) String() string {
@@ -86,6 +92,12 @@ func dbg(format string, args ...interface{}) {
 
 // Parser is the state for the parser.
 type Parser struct {
+	// KeepComments specifies whether to return the comments inside rules, as
+	// CommentGrammar between declarations and rules, and as CommentTokens in
+	// the values of selectors, declarations and at-rule preludes. Otherwise,
+	// only top-level comments are returned.
+	KeepComments bool
+
 	l      *Lexer
 	state  []State
 	err    string
@@ -96,6 +108,9 @@ type Parser struct {
 
 	data        []byte
 	tt          TokenType
+	offset      int
+	popOffset   int
+	wsOffset    int
 	keepWS      bool
 	prevWS      bool
 	prevEnd     bool
@@ -137,10 +152,11 @@ func (p *Parser) Next() (GrammarType, TokenType, []byte) {
 	p.err = ""
 
 	if p.prevEnd {
-		p.tt, p.data = RightBraceToken, endBytes
+		p.tt, p.data, p.offset = RightBraceToken, endBytes, -1
 		p.prevEnd = false
 	} else {
 		p.tt, p.data = p.popToken(true)
+		p.offset = p.popOffset
 	}
 	gt := p.state[len(p.state)-1](p)
 	return gt, p.tt, p.data
@@ -151,20 +167,36 @@ func (p *Parser) Values() []Token {
 	return p.buf
 }
 
+// Offset returns the byte offset in the input of the token returned by the
+// last call to Next, such as the name of a declaration, or -1 if it was
+// inserted by the parser.
+func (p *Parser) Offset() int {
+	return p.offset
+}
+
+// popToken returns the next token, skipping whitespace and comments, and
+// sets popOffset to its offset and wsOffset to the offset of the whitespace
+// before it, or -1 if there is none.
 func (p *Parser) popToken(allowComment bool) (TokenType, []byte) {
 	p.prevWS = false
 	p.prevComment = false
+	p.wsOffset = -1
 	tt, data := p.l.Next()
+	p.popOffset = p.l.r.Offset() - len(data)
 	for !p.keepWS && tt == WhitespaceToken || tt == CommentToken {
 		if tt == WhitespaceToken {
 			p.prevWS = true
+			if p.wsOffset < 0 {
+				p.wsOffset = p.popOffset
+			}
 		} else {
 			p.prevComment = true
-			if allowComment && len(p.state) == 1 {
+			if allowComment && (len(p.state) == 1 || p.KeepComments) {
 				break
 			}
 		}
 		tt, data = p.l.Next()
+		p.popOffset = p.l.r.Offset() - len(data)
 	}
 	return tt, data
 }
@@ -173,8 +205,8 @@ func (p *Parser) initBuf() {
 	p.buf = p.buf[:0]
 }
 
-func (p *Parser) pushBuf(tt TokenType, data []byte) {
-	p.buf = append(p.buf, Token{tt, data})
+func (p *Parser) pushBuf(tt TokenType, data []byte, offset int) {
+	p.buf = append(p.buf, Token{tt, data, offset})
 }
 
 ////////////////////////////////////////////////////////////////
@@ -194,10 +226,18 @@ func (p *Parser) parseStylesheet() GrammarType {
 
 func (p *Parser) parseDeclarationList() GrammarType {
 	if p.tt == CommentToken {
+		if p.KeepComments {
+			return CommentGrammar
+		}
 		p.tt, p.data = p.popToken(false)
+		p.offset = p.popOffset
 	}
 	for p.tt == SemicolonToken {
-		p.tt, p.data = p.popToken(false)
+		p.tt, p.data = p.popToken(p.KeepComments)
+		p.offset = p.popOffset
+	}
+	if p.tt == CommentToken {
+		return CommentGrammar
 	}
 
 	// IE hack: *color:red;
@@ -226,10 +266,10 @@ func (p *Parser) parseDeclarationList() GrammarType {
 	if p.tt == RightBraceToken {
 		// right brace token will occur when we've had a decl error that ended in a right brace token
 		// as these are not handled by decl error, we handle it here explicitly. Normally its used to end eg. the qual rule.
-		p.pushBuf(p.tt, p.data)
+		p.pushBuf(p.tt, p.data, p.offset)
 		return ErrorGrammar
 	}
-	return p.parseDeclarationError(p.tt, p.data)
+	return p.parseDeclarationError(p.tt, p.data, p.offset)
 }
 
 ////////////////////////////////////////////////////////////////
@@ -248,11 +288,11 @@ func (p *Parser) parseAtRule() GrammarType {
 	first := true
 	skipWS := false
 	for {
-		tt, data := p.popToken(false)
+		tt, data := p.popToken(p.KeepComments)
 		if tt == LeftBraceToken && p.level == 0 {
-			if atRule == Font_Face || atRule == Page {
+			if atRule == Counter_Style || atRule == Font_Face || atRule == Page || atRule == Property {
 				p.state = append(p.state, (*Parser).parseAtRuleDeclarationList)
-			} else if atRule == Document || atRule == Keyframes || atRule == Media || atRule == Supports {
+			} else if atRule == Container || atRule == Document || atRule == Keyframes || atRule == Media || atRule == Supports {
 				p.state = append(p.state, (*Parser).parseAtRuleRuleList)
 			} else {
 				p.state = append(p.state, (*Parser).parseAtRuleUnknown)
@@ -275,14 +315,14 @@ func (p *Parser) parseAtRule() GrammarType {
 		if len(data) == 1 && (data[0] == ',' || data[0] == ':') {
 			skipWS = true
 		} else if p.prevWS && !skipWS && tt != RightParenthesisToken {
-			p.pushBuf(WhitespaceToken, wsBytes)
+			p.pushBuf(WhitespaceToken, wsBytes, p.wsOffset)
 		} else {
 			skipWS = false
 		}
 		if tt == LeftParenthesisToken {
 			skipWS = true
 		}
-		p.pushBuf(tt, data)
+		p.pushBuf(tt, data, p.popOffset)
 	}
 }
 
@@ -290,6 +330,8 @@ func (p *Parser) parseAtRuleRuleList() GrammarType {
 	if p.tt == RightBraceToken || p.tt == ErrorToken {
 		p.state = p.state[:len(p.state)-1]
 		return EndAtRuleGrammar
+	} else if p.tt == CommentToken {
+		return CommentGrammar
 	} else if p.tt == AtKeywordToken {
 		return p.parseAtRule()
 	} else {
@@ -299,7 +341,8 @@ func (p *Parser) parseAtRuleRuleList() GrammarType {
 
 func (p *Parser) parseAtRuleDeclarationList() GrammarType {
 	for p.tt == SemicolonToken {
-		p.tt, p.data = p.popToken(false)
+		p.tt, p.data = p.popToken(p.KeepComments)
+		p.offset = p.popOffset
 	}
 	if p.tt == RightBraceToken || p.tt == ErrorToken {
 		p.state = p.state[:len(p.state)-1]
@@ -330,14 +373,16 @@ func (p *Parser) parseQualifiedRule() GrammarType {
 	skipWS := true
 	var tt TokenType
 	var data []byte
+	var offset int
 	for {
 		if first {
-			tt, data = p.tt, p.data
+			tt, data, offset = p.tt, p.data, p.offset
 			p.tt = WhitespaceToken
 			p.data = emptyBytes
 			first = false
 		} else {
-			tt, data = p.popToken(false)
+			tt, data = p.popToken(p.KeepComments)
+			offset = p.popOffset
 		}
 		dbg("> tt=%s, data=%s", tt, string(data))
 		if tt == LeftBraceToken && p.level == 0 {
@@ -357,7 +402,7 @@ func (p *Parser) parseQualifiedRule() GrammarType {
 			}
 			skipWS = true
 		} else if p.prevWS && !skipWS && !inAttrSel {
-			p.pushBuf(WhitespaceToken, wsBytes)
+			p.pushBuf(WhitespaceToken, wsBytes, p.wsOffset)
 		} else {
 			skipWS = false
 		}
@@ -366,13 +411,14 @@ func (p *Parser) parseQualifiedRule() GrammarType {
 		} else if tt == RightBracketToken {
 			inAttrSel = false
 		}
-		p.pushBuf(tt, data)
+		p.pushBuf(tt, data, offset)
 	}
 }
 
 func (p *Parser) parseQualifiedRuleDeclarationList() GrammarType {
 	for p.tt == SemicolonToken {
-		p.tt, p.data = p.popToken(false)
+		p.tt, p.data = p.popToken(p.KeepComments)
+		p.offset = p.popOffset
 	}
 	if p.tt == RightBraceToken || p.tt == ErrorToken {
 		p.state = p.state[:len(p.state)-1]
@@ -391,13 +437,13 @@ func (p *Parser) parseDeclaration() GrammarType {
 		p.l.r.Move(-len(data))
 		p.err, p.errPos = "CSS parse error: expected colon in declaration", p.l.r.Offset()
 		p.l.r.Move(len(data))
-		p.pushBuf(ttName, dataName)
-		return p.parseDeclarationError(tt, data)
+		p.pushBuf(ttName, dataName, p.offset)
+		return p.parseDeclarationError(tt, data, p.popOffset)
 	}
 
 	skipWS := true
 	for {
-		tt, data := p.popToken(false)
+		tt, data := p.popToken(p.KeepComments)
 		if (tt == SemicolonToken || tt == RightBraceToken) && p.level == 0 || tt == ErrorToken {
 			p.prevEnd = (tt == RightBraceToken)
 			return DeclarationGrammar
@@ -407,24 +453,29 @@ func (p *Parser) parseDeclaration() GrammarType {
 			p.level--
 		}
 		if len(data) == 1 && (data[0] == ',' || data[0] == '/' || data[0] == ':' || data[0] == '!' || data[0] == '=') {
+			if data[0] == ':' && p.prevWS && !skipWS {
+				// Keep the whitespace which separates a colon from the previous
+				// value, as in "1s :global(fade)".
+				p.pushBuf(WhitespaceToken, wsBytes, p.wsOffset)
+			}
 			skipWS = true
 		} else if (p.prevWS || p.prevComment) && !skipWS {
-			p.pushBuf(WhitespaceToken, wsBytes)
+			p.pushBuf(WhitespaceToken, wsBytes, p.wsOffset)
 		} else {
 			skipWS = false
 		}
-		p.pushBuf(tt, data)
+		p.pushBuf(tt, data, p.popOffset)
 	}
 }
 
-func (p *Parser) parseDeclarationError(tt TokenType, data []byte) GrammarType {
+func (p *Parser) parseDeclarationError(tt TokenType, data []byte, offset int) GrammarType {
 	// we're on the offending (tt,data), keep popping tokens till we reach ;, }, or EOF
 	p.tt, p.data = tt, data
 	for {
 		if (tt == SemicolonToken || tt == RightBraceToken) && p.level == 0 || tt == ErrorToken {
 			p.prevEnd = (tt == RightBraceToken)
 			if tt == SemicolonToken {
-				p.pushBuf(tt, data)
+				p.pushBuf(tt, data, offset)
 			}
 			return ErrorGrammar
 		} else if tt == LeftParenthesisToken || tt == LeftBraceToken || tt == LeftBracketToken || tt == FunctionToken {
@@ -434,11 +485,12 @@ func (p *Parser) parseDeclarationError(tt TokenType, data []byte) GrammarType {
 		}
 
 		if p.prevWS {
-			p.pushBuf(WhitespaceToken, wsBytes)
+			p.pushBuf(WhitespaceToken, wsBytes, p.wsOffset)
 		}
-		p.pushBuf(tt, data)
+		p.pushBuf(tt, data, offset)
 
 		tt, data = p.popToken(false)
+		offset = p.popOffset
 	}
 }
 
@@ -451,11 +503,12 @@ func (p *Parser) parseCustomProperty() GrammarType {
 		return ErrorGrammar
 	}
 	val := []byte{}
+	offset := p.l.r.Offset()
 	for {
 		tt, data := p.l.Next()
 		if (tt == SemicolonToken || tt == RightBraceToken) && p.level == 0 || tt == ErrorToken {
 			p.prevEnd = (tt == RightBraceToken)
-			p.pushBuf(CustomPropertyValueToken, val)
+			p.pushBuf(CustomPropertyValueToken, val, offset)
 			return CustomPropertyGrammar
 		} else if tt == LeftParenthesisToken || tt == LeftBraceToken || tt == LeftBracketToken || tt == FunctionToken {
 			p.level++
@@ -0,0 +1,178 @@
+package cssbuild
+
+import (
+	"strings"
+
+	"github.com/bduffany/cssbuild/cssbuild/css"
+	"github.com/tdewolff/parse/v2"
+)
+
+// customPropertyRefs resolves the custom properties declared and referenced
+// by a module. If custom properties aren't scoped, it leaves them unchanged.
+type customPropertyRefs struct {
+	enabled bool
+
+	// local is the set of custom properties declared in locally scoped rules.
+	local map[string]bool
+
+	// global is the set of custom properties which are never scoped.
+	global map[string]bool
+
+	suffix []byte
+}
+
+// newCustomPropertyRefs returns the resolver for the custom properties of a
+// module with the given definitions.
+func newCustomPropertyRefs(defs *definitions, opts *TransformOpts) *customPropertyRefs {
+	r := &customPropertyRefs{
+		enabled: opts.ScopeCustomProperties,
+		local:   defs.customProperties,
+		global:  map[string]bool{},
+		suffix:  opts.Suffix,
+	}
+	for _, name := range opts.GlobalCustomProperties {
+		r.global[name] = true
+	}
+	return r
+}
+
+// isPropertyRule returns whether the at-rule with the given name registers a
+// custom property.
+func isPropertyRule(name string) bool {
+	return name == "@property"
+}
+
+// propertyName returns the index of the custom property in the prelude of a
+// @property rule, or -1 if there is none, along with its scope. The name may
+// be wrapped in global() or local(), as in "@property :global(--x)", and the
+// index of the closing parenthesis is returned as the end of the name.
+func propertyName(values []css.Token) (name, end int, scope scopeType) {
+	i := skipWhitespace(values, 0)
+	if i == len(values) {
+		return -1, -1, local
+	}
+	if name, end, scope, ok := scopedName(values, i); ok && values[name].TokenType == css.CustomPropertyNameToken {
+		return name, end, scope
+	}
+	if values[i].TokenType == css.CustomPropertyNameToken {
+		return i, i, local
+	}
+	return -1, -1, local
+}
+
+// declare returns the name of a custom property declared in a block with the
+// given scope, suffixed if the declaration is locally scoped.
+func (r *customPropertyRefs) declare(name css.Token, scope scopeType, js *jsMappings) css.Token {
+	if !r.enabled || scope != local || r.global[string(name.Data)] {
+		return name
+	}
+	js.CustomProperties[string(name.Data)] = struct{}{}
+	return withSuffix(name, r.suffix)
+}
+
+// resolve returns the given custom property reference, suffixed if it refers
+// to a locally declared custom property, or if it is wrapped in local().
+func (r *customPropertyRefs) resolve(name css.Token, scope scopeType, explicit bool) css.Token {
+	if scope == global {
+		return name
+	}
+	if explicit || r.local[string(name.Data)] && !r.global[string(name.Data)] {
+		return withSuffix(name, r.suffix)
+	}
+	return name
+}
+
+// transformVarReferences suffixes the custom properties referenced by the
+// var() functions in the given values, which are in a block with the given
+// scope. A reference wrapped in global() or local(), such as
+// "var(:global(--x))", is scoped accordingly regardless of the block's scope,
+// and the wrapper is removed.
+func transformVarReferences(values []css.Token, scope scopeType, r *customPropertyRefs) []css.Token {
+	if !r.enabled {
+		return values
+	}
+	out := make([]css.Token, 0, len(values))
+	for i := 0; i < len(values); i++ {
+		val := values[i]
+		out = append(out, val)
+		if val.TokenType != css.FunctionToken || strings.ToLower(string(val.Data)) != "var(" {
+			continue
+		}
+		j := skipWhitespace(values, i+1)
+		out = append(out, values[i+1:j]...)
+		i = j - 1
+		if j == len(values) {
+			continue
+		}
+		if name, end, nameScope, ok := scopedName(values, j); ok && values[name].TokenType == css.CustomPropertyNameToken {
+			out = append(out, r.resolve(values[name], nameScope, true))
+			i = end
+		} else if values[j].TokenType == css.CustomPropertyNameToken {
+			out = append(out, r.resolve(values[j], scope, false))
+			i = j
+		}
+	}
+	return out
+}
+
+// isPropertyNameListProperty returns whether the property with the given name
+// lists other properties by name, which may be custom properties registered
+// with @property.
+func isPropertyNameListProperty(name string) bool {
+	switch name {
+	case "transition", "transition-property", "will-change", "-webkit-transition", "-webkit-transition-property":
+		return true
+	}
+	return false
+}
+
+// transformPropertyNameReferences suffixes the custom properties named by the
+// value of a transition, transition-property or will-change property, as in
+// "transition: --angle 1s", which are in a block with the given scope. Like
+// var() references, they may be wrapped in global() or local().
+func transformPropertyNameReferences(values []css.Token, scope scopeType, r *customPropertyRefs) []css.Token {
+	if !r.enabled {
+		return values
+	}
+	out := make([]css.Token, 0, len(values))
+	depth := 0
+	for i := 0; i < len(values); i++ {
+		val := values[i]
+		if depth == 0 {
+			if name, end, nameScope, ok := scopedName(values, i); ok && values[name].TokenType == css.CustomPropertyNameToken {
+				out = append(out, r.resolve(values[name], nameScope, true))
+				i = end
+				continue
+			}
+			if val.TokenType == css.CustomPropertyNameToken {
+				val = r.resolve(val, scope, false)
+			}
+		}
+		depth += nestingDelta(val)
+		out = append(out, val)
+	}
+	return out
+}
+
+// transformCustomPropertyValue suffixes the custom properties referenced by
+// the value of a custom property. The parser returns the value as a single
+// token, since it may contain almost anything, so it is tokenized here.
+func transformCustomPropertyValue(values []css.Token, scope scopeType, r *customPropertyRefs) []css.Token {
+	if !r.enabled || len(values) != 1 {
+		return values
+	}
+	l := css.NewLexer(parse.NewInputBytes(append([]byte(nil), values[0].Data...)))
+	var tokens []css.Token
+	for {
+		tt, data := l.Next()
+		if tt == css.ErrorToken {
+			break
+		}
+		tokens = append(tokens, css.Token{TokenType: tt, Data: data, Offset: -1})
+	}
+	var data []byte
+	for _, val := range transformVarReferences(tokens, scope, r) {
+		data = append(data, val.Data...)
+	}
+	return []css.Token{{TokenType: values[0].TokenType, Data: data, Offset: values[0].Offset}}
+}
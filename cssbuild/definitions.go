@@ -0,0 +1,78 @@
+package cssbuild
+
+import (
+	"github.com/bduffany/cssbuild/cssbuild/css"
+	"github.com/tdewolff/parse/v2"
+)
+
+// definitions are the names defined by a module stylesheet, which references
+// to them are resolved against.
+type definitions struct {
+	keyframes *keyframesSet
+
+	// customProperties is the set of custom properties declared in locally
+	// scoped rules. It is only collected if custom properties are scoped.
+	customProperties map[string]bool
+
+	// counterStyles is the set of counter styles defined by locally scoped
+	// @counter-style rules.
+	counterStyles map[string]bool
+}
+
+// collectDefinitions returns the names defined by the given stylesheet, so
+// that references to them can be resolved before the definitions are reached.
+// Parse errors are left to the transform to report.
+func collectDefinitions(src []byte, opts *TransformOpts) *definitions {
+	defs := &definitions{
+		keyframes:        &keyframesSet{local: map[string]bool{}, global: map[string]bool{}},
+		customProperties: map[string]bool{},
+		counterStyles:    map[string]bool{},
+	}
+	// Selectors are transformed to find the scope of their blocks, with
+	// throwaway mappings.
+	js := &jsMappings{ClassNames: map[string]struct{}{}}
+	blockScope := local
+	// The parser lowercases parts of its input in place.
+	p := css.NewParser(parse.NewInputBytes(append([]byte(nil), src...)), false /*=inline*/)
+	for {
+		gt, _, text := p.Next()
+		values := p.Values()
+		switch gt {
+		case css.ErrorGrammar:
+			return defs
+		case css.BeginRulesetGrammar:
+			_, blockScope = transformSelector(text, values, opts, js)
+		case css.EndRulesetGrammar, css.EndAtRuleGrammar:
+			blockScope = local
+		case css.BeginAtRuleGrammar:
+			if isPropertyRule(string(text)) {
+				if i, _, scope := propertyName(values); i >= 0 && opts.ScopeCustomProperties && scope == local {
+					defs.customProperties[string(values[i].Data)] = true
+				}
+				continue
+			}
+			if isCounterStyleRule(string(text)) {
+				if i, _, scope := counterStyleName(values); i >= 0 && scope == local {
+					defs.counterStyles[string(values[i].Data)] = true
+				}
+				continue
+			}
+			if !isKeyframesRule(string(text)) {
+				continue
+			}
+			i, scope := keyframesName(values)
+			if i < 0 {
+				continue
+			}
+			if scope == local {
+				defs.keyframes.local[animationName(values[i])] = true
+			} else {
+				defs.keyframes.global[animationName(values[i])] = true
+			}
+		case css.CustomPropertyGrammar:
+			if opts.ScopeCustomProperties && blockScope == local {
+				defs.customProperties[string(text)] = true
+			}
+		}
+	}
+}
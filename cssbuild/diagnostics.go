@@ -0,0 +1,38 @@
+package cssbuild
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// diagnostics reports problems with the input stylesheet which don't stop it
+// from being transformed. They are written as warnings, or in strict mode,
+// the first one fails the transform.
+type diagnostics struct {
+	w      io.Writer
+	strict bool
+	src    []byte
+
+	// err is the first problem reported in strict mode.
+	err error
+}
+
+// warn reports a problem at the given offset in the source stylesheet, or at
+// no particular position if the offset is negative.
+func (d *diagnostics) warn(offset int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if offset >= 0 && offset <= len(d.src) {
+		line := bytes.Count(d.src[:offset], []byte("\n")) + 1
+		msg = fmt.Sprintf("line %d: %s", line, msg)
+	}
+	if d.strict {
+		if d.err == nil {
+			d.err = fmt.Errorf("%s", msg)
+		}
+		return
+	}
+	if d.w != nil {
+		fmt.Fprintf(d.w, "%s\n", msg)
+	}
+}
@@ -0,0 +1,78 @@
+package cssbuild
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// unescape returns the given identifier or string contents with CSS escapes
+// replaced by the characters which they stand for.
+//
+// Reference: https://www.w3.org/TR/css-syntax-3/#consume-escaped-code-point
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		n := 0
+		for n < 6 && i+n < len(s) && isHexDigit(s[i+n]) {
+			n++
+		}
+		if n == 0 {
+			if s[i] == '\n' || s[i] == '\f' {
+				// A line continuation in a string.
+				continue
+			}
+			if s[i] == '\r' {
+				if i+1 < len(s) && s[i+1] == '\n' {
+					i++
+				}
+				continue
+			}
+			b.WriteByte(s[i])
+			continue
+		}
+		code, _ := strconv.ParseUint(s[i:i+n], 16, 32)
+		r := rune(code)
+		if r == 0 || r > utf8.MaxRune || 0xD800 <= r && r <= 0xDFFF {
+			r = utf8.RuneError
+		}
+		b.WriteRune(r)
+		i += n
+		// A single whitespace character terminates a hex escape.
+		if i < len(s) && isWhitespace(s[i]) {
+			if s[i] == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+		} else {
+			i--
+		}
+	}
+	return b.String()
+}
+
+// endsWithHexEscape returns whether the given identifier ends with a hex
+// escape of less than 6 digits with no terminating whitespace, which would
+// absorb any hex digits appended to it.
+func endsWithHexEscape(b []byte) bool {
+	n := 0
+	for n < 6 && n < len(b) && isHexDigit(b[len(b)-1-n]) {
+		n++
+	}
+	if n == 0 || n == 6 || n == len(b) || b[len(b)-1-n] != '\\' {
+		return false
+	}
+	// The backslash must not itself be escaped.
+	backslashes := 0
+	for i := len(b) - 1 - n; i >= 0 && b[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
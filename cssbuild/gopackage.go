@@ -0,0 +1,126 @@
+package cssbuild
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const goHeaderTemplate = `// Code generated by cssbuild. DO NOT EDIT.
+
+package %s
+`
+
+// WriteGo writes a Go source file declaring the mappings as structs with one
+// field per identifier, so that references are checked at compile time.
+func (m *jsMappings) WriteGo(w io.Writer, opts *TransformOpts) error {
+	if !token.IsIdentifier(opts.GoPackageName) {
+		return fmt.Errorf("invalid Go package name %q", opts.GoPackageName)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, goHeaderTemplate, opts.GoPackageName)
+	if opts.GoEmbedPath != "" {
+		if strings.HasPrefix(opts.GoEmbedPath, "../") || strings.HasPrefix(opts.GoEmbedPath, "/") {
+			return fmt.Errorf("CSS output %q must be in the same directory as the Go output, or a subdirectory", opts.GoEmbedPath)
+		}
+		embedPath := opts.GoEmbedPath
+		if strings.ContainsAny(embedPath, " \t\"") {
+			embedPath = strconv.Quote(embedPath)
+		}
+		b.WriteString("\nimport _ \"embed\"\n\n")
+		b.WriteString("// CSS is the transformed stylesheet.\n//\n")
+		fmt.Fprintf(&b, "//go:embed %s\n", embedPath)
+		b.WriteString("var CSS string\n")
+	}
+	if err := writeGoStruct(&b, opts, "ClassNames", "locally scoped class names", m.ClassNames); err != nil {
+		return err
+	}
+	if err := writeGoStruct(&b, opts, "AnimationNames", "locally scoped animation names", m.AnimationNames); err != nil {
+		return err
+	}
+	if len(m.ContainerNames) > 0 {
+		if err := writeGoStruct(&b, opts, "ContainerNames", "locally scoped container names", m.ContainerNames); err != nil {
+			return err
+		}
+	}
+	if len(m.CounterNames) > 0 {
+		if err := writeGoStruct(&b, opts, "CounterNames", "locally scoped counter names", m.CounterNames); err != nil {
+			return err
+		}
+	}
+	if len(m.CounterStyles) > 0 {
+		if err := writeGoStruct(&b, opts, "CounterStyles", "locally scoped counter styles", m.CounterStyles); err != nil {
+			return err
+		}
+	}
+	if opts.ScopeCustomProperties {
+		if err := writeGoStruct(&b, opts, "CustomProperties", "locally scoped custom properties", m.CustomProperties); err != nil {
+			return err
+		}
+	}
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format Go source: %s", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func writeGoStruct(b *bytes.Buffer, opts *TransformOpts, name, description string, mapping map[string]struct{}) error {
+	fieldToOriginal := map[string]string{}
+	for _, c := range sortedKeys(mapping) {
+		field := toGoFieldName(c)
+		if conflict := fieldToOriginal[field]; conflict != "" {
+			return fmt.Errorf("identifiers %q and %q have the same Go field name %q; rename to avoid conflict", conflict, c, field)
+		}
+		fieldToOriginal[field] = c
+	}
+	fields := sortedKeys(stringSet(fieldToOriginal))
+
+	fmt.Fprintf(b, "\n// %s maps %s to their suffixed names.\n", name, description)
+	fmt.Fprintf(b, "var %s = struct {\n", name)
+	for _, f := range fields {
+		fmt.Fprintf(b, "%s string\n", f)
+	}
+	b.WriteString("}{\n")
+	for _, f := range fields {
+		fmt.Fprintf(b, "%s: %s,\n", f, strconv.Quote(fieldToOriginal[f]+string(opts.Suffix)))
+	}
+	b.WriteString("}\n")
+	return nil
+}
+
+// toGoFieldName converts a CSS identifier to an exported Go identifier. For
+// example, "foo-bar" becomes "FooBar".
+func toGoFieldName(ident string) string {
+	out := ""
+	upper := true
+	for _, r := range ident {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		out += string(r)
+	}
+	if out == "" || !unicode.IsUpper([]rune(out)[0]) {
+		out = "X" + out
+	}
+	return out
+}
+
+func stringSet(m map[string]string) map[string]struct{} {
+	set := make(map[string]struct{}, len(m))
+	for k := range m {
+		set[k] = struct{}{}
+	}
+	return set
+}
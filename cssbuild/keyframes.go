@@ -0,0 +1,103 @@
+package cssbuild
+
+import (
+	"strings"
+
+	"github.com/bduffany/cssbuild/cssbuild/css"
+)
+
+// isKeyframesRule returns whether the at-rule with the given name defines
+// keyframes.
+func isKeyframesRule(name string) bool {
+	return name == "@keyframes" || name == "@-webkit-keyframes" || name == "@-moz-keyframes"
+}
+
+// keyframesName returns the index of the name in the prelude of a @keyframes
+// rule, or -1 if there is none, along with the scope given to the name by a
+// :global or :local prefix.
+func keyframesName(values []css.Token) (int, scopeType) {
+	scope := local
+	for i, val := range values {
+		switch val.TokenType {
+		case css.FunctionToken:
+			if strings.ToLower(string(val.Data)) == "global(" {
+				scope = global
+			}
+		case css.StringToken:
+			return i, scope
+		case css.IdentToken:
+			if i > 0 && values[i-1].TokenType == css.ColonToken {
+				// The :global or :local mode, rather than the name.
+				if strings.ToLower(string(val.Data)) == "global" {
+					scope = global
+				}
+				continue
+			}
+			return i, scope
+		}
+	}
+	return -1, scope
+}
+
+// keyframesSet is the set of animation names defined by the @keyframes rules
+// of a module.
+type keyframesSet struct {
+	local, global map[string]bool
+}
+
+// animationRefs resolves the animation names referenced by animation
+// properties against the @keyframes rules of the module.
+type animationRefs struct {
+	keyframes *keyframesSet
+	diag      *diagnostics
+	suffix    []byte
+}
+
+// resolve returns the given animation name, suffixed if it refers to locally
+// scoped keyframes. Names which don't match any keyframes in the module are
+// left global, since they may refer to keyframes from another stylesheet.
+func (r *animationRefs) resolve(val css.Token) css.Token {
+	name := animationName(val)
+	if r.keyframes.local[name] {
+		return withNameSuffix(val, r.suffix)
+	}
+	if !r.keyframes.global[name] {
+		r.diag.warn(val.Offset, "animation name %q does not match any @keyframes rule in the module", name)
+	}
+	return val
+}
+
+// resolveScoped returns the given animation name from a global() or local()
+// function, suffixed if the scope is local.
+func (r *animationRefs) resolveScoped(val css.Token, scope scopeType) css.Token {
+	if scope == global {
+		return val
+	}
+	name := animationName(val)
+	if !r.keyframes.local[name] {
+		r.diag.warn(val.Offset, "animation name %q in local() does not match any locally scoped @keyframes rule in the module", name)
+	}
+	return withNameSuffix(val, r.suffix)
+}
+
+// animationName returns the name given by an identifier or string token, with
+// any escapes replaced. Quoted and escaped names are the same as the
+// identifier which they spell out.
+func animationName(val css.Token) string {
+	if val.TokenType == css.StringToken {
+		return unescape(unquote(string(val.Data)))
+	}
+	return unescape(string(val.Data))
+}
+
+// unquote returns the contents of a string token.
+func unquote(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	if len(s) > 1 && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	// The closing quote may be missing at the end of the stylesheet.
+	return s[1:]
+}
@@ -0,0 +1,195 @@
+// Package mappings loads the identifier mappings generated by cssbuild, and
+// exposes them to Go templates.
+package mappings
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// Module is the set of mappings from the original CSS identifiers in a module
+// stylesheet to suffixed ones. It is the structure of the JSON mappings file
+// written by cssbuild.
+type Module struct {
+	ClassNames     map[string]string `json:"classNames"`
+	AnimationNames map[string]string `json:"animationNames"`
+
+	// ContainerNames, CounterNames and CounterStyles are only written if the
+	// module scopes any container names, counters or counter styles.
+	ContainerNames map[string]string `json:"containerNames,omitempty"`
+	CounterNames   map[string]string `json:"counterNames,omitempty"`
+	CounterStyles  map[string]string `json:"counterStyles,omitempty"`
+
+	// CustomProperties maps custom property names without the leading "--"
+	// to suffixed ones. It is only written if custom properties are scoped.
+	CustomProperties map[string]string `json:"customProperties,omitempty"`
+}
+
+// Registry holds the mappings for one or more modules, keyed by a name chosen
+// by the caller.
+type Registry struct {
+	modules map[string]*Module
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{modules: map[string]*Module{}}
+}
+
+// Add registers a module under the given name.
+func (r *Registry) Add(name string, m *Module) error {
+	if _, ok := r.modules[name]; ok {
+		return fmt.Errorf("module %q is already registered", name)
+	}
+	r.modules[name] = m
+	return nil
+}
+
+// LoadJSON reads a JSON mappings file from the given reader, and registers it
+// under the given name.
+func (r *Registry) LoadJSON(name string, rd io.Reader) error {
+	m := &Module{}
+	if err := json.NewDecoder(rd).Decode(m); err != nil {
+		return fmt.Errorf("failed to parse mappings for module %q: %s", name, err)
+	}
+	return r.Add(name, m)
+}
+
+// LoadFile reads a JSON mappings file from the given path, and registers it
+// under the given name.
+func (r *Registry) LoadFile(name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.LoadJSON(name, f)
+}
+
+// LoadFS is like LoadFile, but reads from the given filesystem, such as an
+// embed.FS.
+func (r *Registry) LoadFS(fsys fs.FS, name, path string) error {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.LoadJSON(name, f)
+}
+
+// ClassNames returns the suffixed names of the given classes in the given
+// module, joined with spaces. It returns an error if the module or any of the
+// classes are unknown.
+func (r *Registry) ClassNames(module string, classes ...string) (string, error) {
+	m, err := r.module(module)
+	if err != nil {
+		return "", err
+	}
+	return lookup(module, "class", m.ClassNames, classes)
+}
+
+// AnimationNames returns the suffixed names of the given animations in the
+// given module, joined with spaces. It returns an error if the module or any
+// of the animations are unknown.
+func (r *Registry) AnimationNames(module string, animations ...string) (string, error) {
+	m, err := r.module(module)
+	if err != nil {
+		return "", err
+	}
+	return lookup(module, "animation", m.AnimationNames, animations)
+}
+
+// ContainerNames returns the suffixed names of the given containers in the
+// given module, joined with spaces. It returns an error if the module or any
+// of the containers are unknown.
+func (r *Registry) ContainerNames(module string, containers ...string) (string, error) {
+	m, err := r.module(module)
+	if err != nil {
+		return "", err
+	}
+	return lookup(module, "container", m.ContainerNames, containers)
+}
+
+// CounterNames returns the suffixed names of the given counters in the given
+// module, joined with spaces. It returns an error if the module or any of the
+// counters are unknown.
+func (r *Registry) CounterNames(module string, counters ...string) (string, error) {
+	m, err := r.module(module)
+	if err != nil {
+		return "", err
+	}
+	return lookup(module, "counter", m.CounterNames, counters)
+}
+
+// CounterStyle returns the suffixed name of the given counter style in the
+// given module. It returns an error if the module or the counter style is
+// unknown.
+func (r *Registry) CounterStyle(module, style string) (string, error) {
+	m, err := r.module(module)
+	if err != nil {
+		return "", err
+	}
+	return lookup(module, "counter style", m.CounterStyles, []string{style})
+}
+
+// CustomProperty returns the suffixed name of the given custom property in
+// the given module, which is given without the leading "--". It returns an
+// error if the module or the custom property are unknown.
+//
+// The name is returned as template.CSS, since html/template doesn't allow
+// plain strings as property names in style attributes. It comes from the
+// mappings, which only hold identifiers declared by the stylesheet.
+func (r *Registry) CustomProperty(module, name string) (template.CSS, error) {
+	m, err := r.module(module)
+	if err != nil {
+		return "", err
+	}
+	out, err := lookup(module, "custom property", m.CustomProperties, []string{name})
+	return template.CSS(out), err
+}
+
+// FuncMap returns template functions backed by the registry:
+//
+//	{{ cls "Button" "primary" "large" }}
+//	{{ animationName "Button" "spin" }}
+//	{{ containerName "Button" "toolbar" }}
+//	{{ counterName "List" "item" }}
+//	{{ counterStyle "List" "thumbs" }}
+//	{{ customProperty "Button" "size" }}
+//
+// Unknown module or identifier names cause a template execution error.
+func (r *Registry) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"cls":            r.ClassNames,
+		"animationName":  r.AnimationNames,
+		"containerName":  r.ContainerNames,
+		"counterName":    r.CounterNames,
+		"counterStyle":   r.CounterStyle,
+		"customProperty": r.CustomProperty,
+	}
+}
+
+func (r *Registry) module(name string) (*Module, error) {
+	m, ok := r.modules[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown CSS module %q", name)
+	}
+	return m, nil
+}
+
+func lookup(module, kind string, mapping map[string]string, keys []string) (string, error) {
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v, ok := mapping[k]
+		if !ok {
+			return "", fmt.Errorf("unknown %s name %q in CSS module %q", kind, k, module)
+		}
+		out = append(out, v)
+	}
+	return strings.Join(out, " "), nil
+}
@@ -0,0 +1,68 @@
+package mappings
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFuncMap(t *testing.T) {
+	r := NewRegistry()
+	checkErr(t, r.LoadFile("Test", "../testdata/expected_output.module.css.json"))
+	checkErr(t, r.Add("Button", &Module{
+		ClassNames: map[string]string{
+			"primary": "primary_abc",
+			"large":   "large_abc",
+		},
+		CustomProperties: map[string]string{
+			"size": "--size_abc",
+		},
+	}))
+
+	for _, test := range []struct {
+		tmpl     string
+		expected string
+		err      string
+	}{
+		{tmpl: `<a class="{{ cls "Button" "primary" "large" }}">`, expected: `<a class="primary_abc large_abc">`},
+		{tmpl: `<a class="{{ cls "Test" "fooBar" }}">`, expected: `<a class="foo-bar__SUFFIX__">`},
+		{tmpl: `<div style="animation-name: {{ animationName "Test" "foo" }}">`, expected: `<div style="animation-name: foo__SUFFIX__">`},
+		{tmpl: `<div style="container-name: {{ containerName "Test" "sidebarPanel" }}">`, expected: `<div style="container-name: sidebar-panel__SUFFIX__">`},
+		{tmpl: `<ol style="counter-reset: {{ counterName "Test" "item" }}; list-style-type: {{ counterStyle "Test" "stars" }}">`, expected: `<ol style="counter-reset: item__SUFFIX__; list-style-type: stars__SUFFIX__">`},
+		{tmpl: `{{ counterStyle "Test" "decimal" }}`, err: `unknown counter style name "decimal" in CSS module "Test"`},
+		{tmpl: `<div style="{{ customProperty "Button" "size" }}: 4px">`, expected: `<div style="--size_abc: 4px">`},
+		{tmpl: `{{ customProperty "Test" "size" }}`, err: `unknown custom property name "size" in CSS module "Test"`},
+		{tmpl: `{{ cls "Button" "primray" }}`, err: `unknown class name "primray" in CSS module "Button"`},
+		{tmpl: `{{ cls "Buton" "primary" }}`, err: `unknown CSS module "Buton"`},
+	} {
+		tmpl := template.Must(template.New("").Funcs(r.FuncMap()).Parse(test.tmpl))
+		var out bytes.Buffer
+		err := tmpl.Execute(&out, nil)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("%s: expected error containing %q, got %v", test.tmpl, test.err, err)
+			}
+			continue
+		}
+		checkErr(t, err)
+		if out.String() != test.expected {
+			t.Fatalf("%s: expected %q, got %q", test.tmpl, test.expected, out.String())
+		}
+	}
+}
+
+func TestAddDuplicate(t *testing.T) {
+	r := NewRegistry()
+	checkErr(t, r.LoadFS(os.DirFS("../testdata"), "Test", "expected_output.module.css.json"))
+	if err := r.Add("Test", &Module{}); err == nil {
+		t.Fatal("expected error when registering duplicate module")
+	}
+}
+
+func checkErr(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
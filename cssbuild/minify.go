@@ -0,0 +1,87 @@
+package cssbuild
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/bduffany/cssbuild/cssbuild/css"
+)
+
+// lengthUnits is the set of length units, for which a zero value may be
+// written without its unit.
+var lengthUnits = map[string]bool{
+	"px": true, "em": true, "rem": true, "ex": true, "ch": true, "cap": true,
+	"ic": true, "lh": true, "rlh": true, "vw": true, "vh": true, "vi": true,
+	"vb": true, "vmin": true, "vmax": true, "cm": true, "mm": true, "q": true,
+	"in": true, "pt": true, "pc": true,
+}
+
+// minifyValues returns the values of a declaration of the given property,
+// with zero lengths and colors shortened where that doesn't change their
+// meaning.
+func minifyValues(property string, values []css.Token) []css.Token {
+	// In the flex shorthand, a unitless zero may be interpreted as a flex
+	// factor instead of a basis, so zero lengths must keep their unit.
+	shortenZeros := !strings.HasSuffix(property, "flex")
+	out := make([]css.Token, 0, len(values))
+	depth := 0
+	for _, val := range values {
+		switch val.TokenType {
+		case css.FunctionToken, css.LeftParenthesisToken:
+			depth++
+		case css.RightParenthesisToken:
+			if depth > 0 {
+				depth--
+			}
+		case css.DimensionToken:
+			if shortenZeros && depth == 0 {
+				val = shortenZeroLength(val)
+			}
+		case css.HashToken:
+			val = shortenHexColor(val)
+		}
+		out = append(out, val)
+	}
+	return out
+}
+
+// isMinifySeparator returns whether whitespace next to the given token is
+// insignificant.
+func isMinifySeparator(val css.Token) bool {
+	return val.TokenType == css.CommaToken || isDelim(val, '!') || isDelim(val, '/')
+}
+
+// shortenZeroLength returns "0" if the given dimension is a zero length.
+func shortenZeroLength(val css.Token) css.Token {
+	i := bytes.LastIndexAny(val.Data, "0123456789.")
+	if i < 0 {
+		return val
+	}
+	n, err := strconv.ParseFloat(string(val.Data[:i+1]), 64)
+	if err != nil || n != 0 || !lengthUnits[strings.ToLower(string(val.Data[i+1:]))] {
+		return val
+	}
+	return css.Token{TokenType: css.NumberToken, Data: []byte("0"), Offset: val.Offset}
+}
+
+// shortenHexColor returns the 3 or 4 digit form of a 6 or 8 digit hex color,
+// if there is one. For example, "#aabbcc" becomes "#abc".
+func shortenHexColor(val css.Token) css.Token {
+	digits := val.Data[1:]
+	if len(digits) != 6 && len(digits) != 8 {
+		return val
+	}
+	short := []byte{'#'}
+	for i := 0; i < len(digits); i += 2 {
+		if !isHexDigit(digits[i]) || digits[i] != digits[i+1] {
+			return val
+		}
+		short = append(short, digits[i])
+	}
+	return css.Token{TokenType: css.HashToken, Data: short, Offset: val.Offset}
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
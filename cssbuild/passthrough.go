@@ -0,0 +1,98 @@
+package cssbuild
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/bduffany/cssbuild/cssbuild/css"
+)
+
+// sourceEdit replaces the bytes of the source stylesheet from start to end
+// with insert.
+type sourceEdit struct {
+	start, end int
+	insert     []byte
+}
+
+// sourceEdits is the list of edits which the transform makes to the source
+// stylesheet, for the passthrough style. A nil list records nothing.
+type sourceEdits struct {
+	src   []byte
+	edits []sourceEdit
+}
+
+// record records the edits which turn the input tokens into the output
+// tokens. The output tokens are the input tokens with some of them removed,
+// and others changed, such as by appending a suffix.
+func (e *sourceEdits) record(in, out []css.Token) {
+	if e == nil {
+		return
+	}
+	outByOffset := make(map[int]css.Token, len(out))
+	for _, val := range out {
+		if val.Offset >= 0 {
+			outByOffset[val.Offset] = val
+		}
+	}
+	for _, val := range in {
+		if val.Offset < 0 {
+			continue
+		}
+		end := val.Offset + len(val.Data)
+		if val.TokenType == css.WhitespaceToken {
+			end = val.Offset
+			for end < len(e.src) && isWhitespace(e.src[end]) {
+				end++
+			}
+		}
+		o, ok := outByOffset[val.Offset]
+		if !ok {
+			e.edits = append(e.edits, sourceEdit{start: val.Offset, end: end})
+		} else if bytes.Equal(o.Data, val.Data) {
+			continue
+		} else if bytes.HasPrefix(o.Data, val.Data) {
+			e.edits = append(e.edits, sourceEdit{start: end, end: end, insert: o.Data[len(val.Data):]})
+		} else {
+			e.edits = append(e.edits, sourceEdit{start: val.Offset, end: end, insert: o.Data})
+		}
+	}
+}
+
+// apply returns the source stylesheet with the edits applied.
+func (e *sourceEdits) apply() []byte {
+	sort.SliceStable(e.edits, func(i, j int) bool {
+		return e.edits[i].start < e.edits[j].start
+	})
+	// Merge adjacent deletions, such as the tokens of ":global".
+	var edits []sourceEdit
+	for _, edit := range e.edits {
+		if n := len(edits); n > 0 && edit.insert == nil && edits[n-1].insert == nil && edits[n-1].end == edit.start {
+			edits[n-1].end = edit.end
+			continue
+		}
+		edits = append(edits, edit)
+	}
+	out := make([]byte, 0, len(e.src))
+	pos := 0
+	for _, edit := range edits {
+		if edit.start < pos {
+			// Overlaps with the previous edit.
+			continue
+		}
+		if edit.insert == nil && edit.start > 0 && isWhitespace(e.src[edit.start-1]) {
+			// Don't leave the whitespace on both sides of a deletion, such as
+			// a trailing ":global" mode selector.
+			for edit.end < len(e.src) && isWhitespace(e.src[edit.end]) {
+				edit.end++
+			}
+		}
+		out = append(out, e.src[pos:edit.start]...)
+		out = append(out, edit.insert...)
+		pos = edit.end
+	}
+	return append(out, e.src[pos:]...)
+}
+
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,536 @@
+package cssbuild
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bduffany/cssbuild/cssbuild/css"
+)
+
+// Style is a formatting style of the transformed stylesheet.
+type Style string
+
+const (
+	// StyleExpanded writes each selector, declaration and closing brace on
+	// its own line, with blank lines between rules. It is the default style.
+	StyleExpanded Style = "expanded"
+
+	// StyleCompact writes each rule on a single line. Blocks containing
+	// other rules, such as @media blocks, have their rules on separate lines.
+	StyleCompact Style = "compact"
+
+	// StyleMinified writes the stylesheet without insignificant whitespace,
+	// comments or final semicolons. License comments starting with "/*!" are
+	// kept. Zero lengths and hex colors in declaration values are also
+	// shortened where that is safe. Like the other styles, the output ends
+	// with a newline.
+	StyleMinified Style = "minified"
+
+	// StylePassthrough copies the input stylesheet byte-for-byte, including
+	// whitespace, comments, quotes and casing, except for the suffixes of
+	// locally scoped identifiers and the removal of :global and :local.
+	StylePassthrough Style = "passthrough"
+)
+
+const defaultIndent = "  "
+
+// NoIndent is the TransformOpts.Indent value which disables indentation,
+// since an empty Indent means the default indentation.
+const NoIndent = "none"
+
+// printer writes the transformed stylesheet in a given style. Write errors
+// are recorded in err, after which nothing else is written.
+type printer struct {
+	w      io.Writer
+	style  Style
+	indent string
+	err    error
+
+	// keepComments is whether comments inside rules are kept, as set by
+	// TransformOpts.PreserveComments.
+	keepComments bool
+
+	// selectors are the selectors of the ruleset which is being started.
+	selectors [][]css.Token
+
+	// selectorComments are comments between the selectors of the ruleset
+	// which is being started, which are written before the next selector.
+	selectorComments []css.Token
+
+	// blocks holds the state of each open block, innermost last.
+	blocks []*printerBlock
+
+	// afterBlock is whether the last item written was a block, so that the
+	// next item at the same level is separated by a blank line.
+	afterBlock bool
+
+	// lineOpen is whether the last line written has not been terminated yet.
+	// It is only used by the compact style, which writes several items per
+	// line, and by the minified style, which writes a single line.
+	lineOpen bool
+
+	// pendingSemicolon is whether the last statement's semicolon hasn't been
+	// written yet, so that it can be dropped at the end of a block when
+	// minifying.
+	pendingSemicolon bool
+}
+
+// printerBlock is the state of a block opened by a ruleset or an at-rule.
+type printerBlock struct {
+	// nested is whether the block contains rules or comments, as opposed to
+	// only declarations.
+	nested bool
+
+	// raw holds the contents of an at-rule block which isn't parsed, which
+	// are written verbatim.
+	raw []byte
+}
+
+func newPrinter(w io.Writer, opts *TransformOpts) (*printer, error) {
+	p := &printer{w: w, style: opts.Style, indent: opts.Indent, keepComments: opts.PreserveComments}
+	switch p.style {
+	case "":
+		p.style = StyleExpanded
+	case StyleExpanded, StyleCompact, StyleMinified, StylePassthrough:
+	default:
+		return nil, fmt.Errorf("unknown style %q", opts.Style)
+	}
+	switch p.indent {
+	case "":
+		p.indent = defaultIndent
+	case NoIndent:
+		p.indent = ""
+	}
+	if strings.Trim(p.indent, " \t") != "" {
+		return nil, fmt.Errorf("indent %q must only contain spaces and tabs", opts.Indent)
+	}
+	return p, nil
+}
+
+// selector adds a selector to the selector list of the next ruleset.
+func (p *printer) selector(tokens []css.Token) {
+	if len(p.selectorComments) > 0 {
+		tokens = append(p.selectorComments, tokens...)
+		p.selectorComments = nil
+	}
+	p.selectors = append(p.selectors, tokens)
+}
+
+// beginRuleset opens a ruleset with the selectors added since the last one.
+func (p *printer) beginRuleset() {
+	var b []byte
+	for i, s := range p.selectors {
+		if i > 0 {
+			switch p.style {
+			case StyleExpanded:
+				b = append(b, ",\n"...)
+				b = append(b, p.currentIndent()...)
+			case StyleCompact:
+				b = append(b, ", "...)
+			case StyleMinified:
+				b = append(b, ',')
+			}
+		}
+		b = append(b, p.formatSelector(s)...)
+	}
+	p.selectors = nil
+	p.beginBlock(b)
+}
+
+// beginAtRule opens an at-rule block with the given name and prelude.
+func (p *printer) beginAtRule(name []byte, prelude []css.Token) {
+	p.beginBlock(p.formatAtRule(name, prelude))
+}
+
+func (p *printer) beginBlock(header []byte) {
+	switch p.style {
+	case StyleMinified:
+		p.flushSemicolon()
+		p.write(header, []byte{'{'})
+		p.lineOpen = true
+	case StyleCompact:
+		p.startNestedLine()
+		p.write(header, []byte(" {"))
+		p.lineOpen = true
+	default:
+		p.startLine()
+		p.write(header, []byte(" {\n"))
+	}
+	p.blocks = append(p.blocks, &printerBlock{})
+	p.afterBlock = false
+}
+
+// raw adds a token of an at-rule block which isn't parsed.
+func (p *printer) raw(text []byte) {
+	if len(p.blocks) == 0 {
+		// Top-level tokens are HTML comment delimiters (<!-- and -->), which
+		// have no effect in stylesheets.
+		return
+	}
+	block := p.blocks[len(p.blocks)-1]
+	block.raw = append(block.raw, text...)
+}
+
+// endBlock closes the innermost block.
+func (p *printer) endBlock() {
+	if len(p.blocks) == 0 {
+		return
+	}
+	block := p.blocks[len(p.blocks)-1]
+	if raw := bytes.TrimSpace(block.raw); len(raw) > 0 {
+		p.statement(raw, false)
+	}
+	p.blocks = p.blocks[:len(p.blocks)-1]
+	switch p.style {
+	case StyleMinified:
+		p.pendingSemicolon = false
+		p.write([]byte{'}'})
+	case StyleCompact:
+		if block.nested {
+			p.endLine()
+			p.write([]byte(p.currentIndent()), []byte{'}'})
+		} else {
+			p.write([]byte(" }"))
+		}
+		p.lineOpen = true
+	default:
+		p.write([]byte(p.currentIndent()), []byte("}\n"))
+	}
+	p.afterBlock = true
+}
+
+// declaration writes a declaration of the given property.
+func (p *printer) declaration(name []byte, values []css.Token) {
+	if p.style == StyleMinified {
+		values = minifyValues(string(name), values)
+	}
+	b := append([]byte{}, name...)
+	b = append(b, p.colon()...)
+	b = append(b, p.formatValues(values)...)
+	p.statement(b, true)
+}
+
+// customProperty writes a custom property declaration. Its value is written
+// verbatim, other than leading and trailing whitespace.
+func (p *printer) customProperty(name []byte, values []css.Token) {
+	b := append([]byte{}, name...)
+	b = append(b, p.colon()...)
+	for _, val := range values {
+		b = append(b, bytes.TrimSpace(val.Data)...)
+	}
+	p.statement(b, true)
+}
+
+// atRule writes an at-rule without a block, such as @import.
+func (p *printer) atRule(name []byte, prelude []css.Token) {
+	p.statement(p.formatAtRule(name, prelude), true)
+}
+
+// comment writes a comment. Only license comments are written when
+// minifying.
+func (p *printer) comment(text []byte) {
+	if p.keepComments && len(p.selectors) > 0 {
+		// The parser returns comments after the comma of a selector list
+		// separately from the next selector.
+		p.selectorComments = append(p.selectorComments,
+			css.Token{TokenType: css.CommentToken, Data: text, Offset: -1},
+			css.Token{TokenType: css.WhitespaceToken, Data: []byte{' '}, Offset: -1})
+		return
+	}
+	if p.style == StyleMinified && !bytes.HasPrefix(text, []byte("/*!")) {
+		return
+	}
+	if len(p.blocks) > 0 {
+		p.blocks[len(p.blocks)-1].nested = true
+	}
+	switch p.style {
+	case StyleMinified:
+		p.flushSemicolon()
+		p.write(text)
+		p.lineOpen = true
+	case StyleCompact:
+		p.startNestedLine()
+		p.write(text)
+		p.lineOpen = true
+	default:
+		p.startLine()
+		p.write(text, []byte{'\n'})
+	}
+	p.afterBlock = false
+}
+
+// statement writes a declaration or an at-rule without a block, which is
+// followed by a semicolon if semicolon is set.
+func (p *printer) statement(b []byte, semicolon bool) {
+	switch p.style {
+	case StyleMinified:
+		p.flushSemicolon()
+		p.write(b)
+		p.pendingSemicolon = semicolon
+		p.lineOpen = true
+		return
+	case StyleCompact:
+		if len(p.blocks) == 0 || p.blocks[len(p.blocks)-1].nested {
+			p.endLine()
+			p.write([]byte(p.currentIndent()))
+		} else {
+			p.write([]byte{' '})
+		}
+		p.write(b)
+		p.lineOpen = true
+	default:
+		p.startLine()
+		p.write(b)
+	}
+	if semicolon {
+		p.write([]byte{';'})
+	}
+	if p.style == StyleExpanded {
+		p.write([]byte{'\n'})
+	}
+	p.afterBlock = false
+}
+
+// finish writes anything which is still pending at the end of the
+// stylesheet.
+func (p *printer) finish() {
+	p.flushSemicolon()
+	p.endLine()
+}
+
+// startLine starts a new line at the current indentation level, separated
+// from a preceding block by a blank line.
+func (p *printer) startLine() {
+	if p.afterBlock {
+		p.write([]byte{'\n'})
+	}
+	p.write([]byte(p.currentIndent()))
+}
+
+// startNestedLine starts a new line in the compact style for a rule or a
+// comment, which are never written on the same line as their parent's
+// declarations.
+func (p *printer) startNestedLine() {
+	if len(p.blocks) > 0 {
+		p.blocks[len(p.blocks)-1].nested = true
+	}
+	p.endLine()
+	p.write([]byte(p.currentIndent()))
+}
+
+func (p *printer) endLine() {
+	if p.lineOpen {
+		p.write([]byte{'\n'})
+		p.lineOpen = false
+	}
+}
+
+func (p *printer) flushSemicolon() {
+	if p.pendingSemicolon {
+		p.write([]byte{';'})
+		p.pendingSemicolon = false
+	}
+}
+
+func (p *printer) currentIndent() string {
+	return strings.Repeat(p.indent, len(p.blocks))
+}
+
+func (p *printer) colon() string {
+	if p.style == StyleMinified {
+		return ":"
+	}
+	return ": "
+}
+
+func (p *printer) write(chunks ...[]byte) {
+	for _, b := range chunks {
+		if p.err != nil {
+			return
+		}
+		_, p.err = p.w.Write(b)
+	}
+}
+
+// formatSelector returns a single selector, with whitespace around
+// combinators and after commas unless minifying.
+func (p *printer) formatSelector(tokens []css.Token) []byte {
+	tokens = p.filterComments(tokens)
+	var b []byte
+	space := false
+	depth := 0
+	for _, val := range tokens {
+		switch {
+		case val.TokenType == css.WhitespaceToken:
+			space = true
+			continue
+		case depth == 0 && isCombinator(val):
+			if len(b) > 0 && p.style != StyleMinified {
+				b = append(b, ' ')
+			}
+			b = append(b, val.Data...)
+			space = p.style != StyleMinified
+			continue
+		case val.TokenType == css.CommaToken:
+			b = append(b, val.Data...)
+			space = p.style != StyleMinified
+			continue
+		}
+		if space && len(b) > 0 && !endsWithByte(b, ' ') && !p.dropsSelectorSpace(b, val) {
+			b = append(b, ' ')
+		}
+		space = false
+		depth += nestingDelta(val)
+		b = append(b, val.Data...)
+	}
+	return b
+}
+
+// dropsSelectorSpace returns whether whitespace between the selector b and
+// the next token is insignificant and dropped by the printer's style, as it
+// is after a comma or an opening parenthesis, or before a closing one, when
+// minifying.
+func (p *printer) dropsSelectorSpace(b []byte, next css.Token) bool {
+	return p.style == StyleMinified && (endsWithByte(b, ',') || endsWithByte(b, '(') || next.TokenType == css.RightParenthesisToken)
+}
+
+// formatAtRule returns an at-rule's name and prelude, with whitespace after
+// commas, and after colons in parenthesized conditions, unless minifying.
+func (p *printer) formatAtRule(name []byte, prelude []css.Token) []byte {
+	b := append([]byte{}, name...)
+	prelude = trimWhitespace(p.filterComments(prelude))
+	if len(prelude) == 0 {
+		return b
+	}
+	if p.style != StyleMinified || prelude[0].TokenType != css.LeftParenthesisToken {
+		b = append(b, ' ')
+	}
+	var parens []css.TokenType
+	space := false
+	for _, val := range prelude {
+		if val.TokenType == css.WhitespaceToken {
+			space = true
+			continue
+		}
+		if space && !(p.style == StyleMinified && endsWithByte(b, ',')) {
+			b = append(b, ' ')
+		}
+		space = false
+		switch val.TokenType {
+		case css.FunctionToken, css.LeftParenthesisToken, css.LeftBracketToken:
+			parens = append(parens, val.TokenType)
+		case css.RightParenthesisToken, css.RightBracketToken:
+			if len(parens) > 0 {
+				parens = parens[:len(parens)-1]
+			}
+		}
+		b = append(b, val.Data...)
+		if p.style != StyleMinified {
+			inCondition := len(parens) > 0 && parens[len(parens)-1] == css.LeftParenthesisToken
+			space = val.TokenType == css.CommaToken || val.TokenType == css.ColonToken && inCondition
+		}
+	}
+	return b
+}
+
+// formatValues returns a declaration value, with whitespace after commas
+// and before "!important" unless minifying.
+func (p *printer) formatValues(values []css.Token) []byte {
+	var b []byte
+	values = trimWhitespace(p.filterComments(values))
+	for i, val := range values {
+		if p.style == StyleMinified {
+			if val.TokenType == css.WhitespaceToken && (isMinifySeparator(values[i-1]) || isMinifySeparator(values[i+1])) {
+				continue
+			}
+			b = append(b, val.Data...)
+			continue
+		}
+		if val.TokenType == css.WhitespaceToken {
+			if !endsWithByte(b, ' ') {
+				b = append(b, ' ')
+			}
+			continue
+		}
+		if isDelim(val, '!') && len(b) > 0 && !endsWithByte(b, ' ') {
+			b = append(b, ' ')
+		}
+		if val.TokenType == css.CommaToken {
+			b = bytes.TrimRight(b, " ")
+		}
+		b = append(b, val.Data...)
+		if val.TokenType == css.CommaToken && i+1 < len(values) {
+			b = append(b, ' ')
+		}
+	}
+	return b
+}
+
+// filterComments returns the tokens without the comments which aren't kept
+// by the printer's style, merging any whitespace around them.
+func (p *printer) filterComments(tokens []css.Token) []css.Token {
+	if p.style != StyleMinified {
+		return tokens
+	}
+	var out []css.Token
+	dropped := false
+	for _, val := range tokens {
+		if val.TokenType == css.CommentToken && !bytes.HasPrefix(val.Data, []byte("/*!")) {
+			dropped = true
+			continue
+		}
+		if val.TokenType == css.WhitespaceToken && dropped && (len(out) == 0 || skipsWhitespaceAfter(out[len(out)-1])) {
+			continue
+		}
+		dropped = false
+		out = append(out, val)
+	}
+	return out
+}
+
+// skipsWhitespaceAfter returns whether the parser drops whitespace after the
+// given token, which makes whitespace after it insignificant.
+func skipsWhitespaceAfter(val css.Token) bool {
+	switch val.TokenType {
+	case css.WhitespaceToken, css.CommaToken, css.ColonToken, css.FunctionToken, css.LeftParenthesisToken:
+		return true
+	}
+	return isCombinator(val) || isDelim(val, '/') || isDelim(val, '!') || isDelim(val, '=')
+}
+
+func isCombinator(val css.Token) bool {
+	return isDelim(val, '>') || isDelim(val, '+') || isDelim(val, '~')
+}
+
+func isDelim(val css.Token, c byte) bool {
+	return val.TokenType == css.DelimToken && len(val.Data) == 1 && val.Data[0] == c
+}
+
+// nestingDelta returns 1 for tokens which open a function, parenthesis or
+// bracket, -1 for tokens which close one, and 0 otherwise.
+func nestingDelta(val css.Token) int {
+	switch val.TokenType {
+	case css.FunctionToken, css.LeftParenthesisToken, css.LeftBracketToken:
+		return 1
+	case css.RightParenthesisToken, css.RightBracketToken:
+		return -1
+	}
+	return 0
+}
+
+func endsWithByte(b []byte, c byte) bool {
+	return len(b) > 0 && b[len(b)-1] == c
+}
+
+// trimWhitespace returns the values without leading and trailing whitespace.
+func trimWhitespace(values []css.Token) []css.Token {
+	for len(values) > 0 && values[0].TokenType == css.WhitespaceToken {
+		values = values[1:]
+	}
+	for len(values) > 0 && values[len(values)-1].TokenType == css.WhitespaceToken {
+		values = values[:len(values)-1]
+	}
+	return values
+}
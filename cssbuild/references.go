@@ -0,0 +1,76 @@
+package cssbuild
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/bduffany/cssbuild/cssbuild/css"
+)
+
+// importReference returns the URL of the stylesheet imported by an @import
+// rule with the given prelude.
+func importReference(values []css.Token) (string, bool) {
+	i := skipWhitespace(values, 0)
+	if i == len(values) {
+		return "", false
+	}
+	if values[i].TokenType == css.StringToken {
+		return stringContents(values[i]), true
+	}
+	return urlReference(values, i)
+}
+
+// urlReference returns the URL given by a url() token, or by a url() or src()
+// function with a string argument, at values[i].
+func urlReference(values []css.Token, i int) (string, bool) {
+	val := values[i]
+	if val.TokenType == css.URLToken {
+		data := val.Data[bytes.IndexByte(val.Data, '(')+1:]
+		data = bytes.TrimSuffix(data, []byte(")"))
+		s := strings.Trim(string(data), " \t\r\n\f")
+		if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+			s = unquote(s)
+		}
+		return unescape(s), true
+	}
+	if val.TokenType != css.FunctionToken {
+		return "", false
+	}
+	if fn := strings.ToLower(string(val.Data)); fn != "url(" && fn != "src(" {
+		return "", false
+	}
+	j := skipWhitespace(values, i+1)
+	if j == len(values) || values[j].TokenType != css.StringToken {
+		return "", false
+	}
+	return stringContents(values[j]), true
+}
+
+// declarationReferences returns the URLs of the files referenced by a
+// declaration: those in url() and src() functions, and the stylesheet named
+// by "from" in a composes declaration.
+func declarationReferences(property string, values []css.Token) []string {
+	var refs []string
+	for i, val := range values {
+		if url, ok := urlReference(values, i); ok {
+			refs = append(refs, url)
+			continue
+		}
+		if property == "composes" && val.TokenType == css.StringToken {
+			k := i - 1
+			for k >= 0 && values[k].TokenType == css.WhitespaceToken {
+				k--
+			}
+			if k >= 0 && values[k].TokenType == css.IdentToken && strings.ToLower(string(values[k].Data)) == "from" {
+				refs = append(refs, stringContents(val))
+			}
+		}
+	}
+	return refs
+}
+
+// stringContents returns the contents of a string token, with any escapes
+// replaced.
+func stringContents(val css.Token) string {
+	return unescape(unquote(string(val.Data)))
+}
@@ -0,0 +1,57 @@
+// Code generated by cssbuild. DO NOT EDIT.
+
+package testdata
+
+import _ "embed"
+
+// CSS is the transformed stylesheet.
+//
+//go:embed expected_output.module.css
+var CSS string
+
+// ClassNames maps locally scoped class names to their suffixed names.
+var ClassNames = struct {
+	Bar     string
+	Baz     string
+	Foo     string
+	FooBar  string
+	List    string
+	Sidebar string
+}{
+	Bar:     "bar__SUFFIX__",
+	Baz:     "baz__SUFFIX__",
+	Foo:     "foo__SUFFIX__",
+	FooBar:  "foo-bar__SUFFIX__",
+	List:    "list__SUFFIX__",
+	Sidebar: "sidebar__SUFFIX__",
+}
+
+// AnimationNames maps locally scoped animation names to their suffixed names.
+var AnimationNames = struct {
+	Foo             string
+	FoobarAnimation string
+}{
+	Foo:             "foo__SUFFIX__",
+	FoobarAnimation: "foobar-animation__SUFFIX__",
+}
+
+// ContainerNames maps locally scoped container names to their suffixed names.
+var ContainerNames = struct {
+	SidebarPanel string
+}{
+	SidebarPanel: "sidebar-panel__SUFFIX__",
+}
+
+// CounterNames maps locally scoped counter names to their suffixed names.
+var CounterNames = struct {
+	Item string
+}{
+	Item: "item__SUFFIX__",
+}
+
+// CounterStyles maps locally scoped counter styles to their suffixed names.
+var CounterStyles = struct {
+	Stars string
+}{
+	Stars: "stars__SUFFIX__",
+}
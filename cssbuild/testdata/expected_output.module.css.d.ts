@@ -1,4 +1,7 @@
 /// <amd-module name="cssbuild/cssbuild/testdata/expected_output.module.css" />
 export declare const classNames: Record<string, string>;
 export declare const animationNames: Record<string, string>;
+export declare const containerNames: Record<string, string>;
+export declare const counterNames: Record<string, string>;
+export declare const counterStyles: Record<string, string>;
 export default classNames;
@@ -17,9 +17,21 @@
     baz: 'baz__SUFFIX__',
     foo: 'foo__SUFFIX__',
     fooBar: 'foo-bar__SUFFIX__',
+    list: 'list__SUFFIX__',
+    sidebar: 'sidebar__SUFFIX__',
   };
   exports.animationNames = {
     foo: 'foo__SUFFIX__',
+    foobarAnimation: 'foobar-animation__SUFFIX__',
+  };
+  exports.containerNames = {
+    sidebarPanel: 'sidebar-panel__SUFFIX__',
+  };
+  exports.counterNames = {
+    item: 'item__SUFFIX__',
+  };
+  exports.counterStyles = {
+    stars: 'stars__SUFFIX__',
   };
   exports.default = exports.classNames;
 });
@@ -3,8 +3,20 @@ export const classNames = {
   baz: 'baz__SUFFIX__',
   foo: 'foo__SUFFIX__',
   fooBar: 'foo-bar__SUFFIX__',
+  list: 'list__SUFFIX__',
+  sidebar: 'sidebar__SUFFIX__',
 };
 export const animationNames = {
   foo: 'foo__SUFFIX__',
+  foobarAnimation: 'foobar-animation__SUFFIX__',
+};
+export const containerNames = {
+  sidebarPanel: 'sidebar-panel__SUFFIX__',
+};
+export const counterNames = {
+  item: 'item__SUFFIX__',
+};
+export const counterStyles = {
+  stars: 'stars__SUFFIX__',
 };
 export default classNames;
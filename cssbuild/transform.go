@@ -2,14 +2,19 @@ package cssbuild
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bduffany/cssbuild/cssbuild/css"
+	"github.com/bduffany/cssbuild/cssbuild/mappings"
 	"github.com/tdewolff/parse/v2"
 )
 
@@ -45,12 +50,28 @@ const (
 	tsDeclarationTemplate = `/// <amd-module name="%s" />
 export declare const classNames: Record<string, string>;
 export declare const animationNames: Record<string, string>;
-export default classNames;
+%sexport default classNames;
 `
+
+	// tsMapDeclarationTemplate declares one of the maps which are only
+	// exported by some modules.
+	tsMapDeclarationTemplate = "export declare const %s: Record<string, string>;\n"
+
+	// customPropertyPrefix is the prefix of custom property names, which is
+	// left out of their keys in the generated mappings.
+	customPropertyPrefix = "--"
 )
 
 type scopeType int
 
+var (
+	// suffixRand generates random suffixes. It is shared by all transforms so
+	// that concurrent transforms never seed their own sources with the same
+	// time, which would give them the same suffix.
+	suffixRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	suffixRandMu sync.Mutex
+)
+
 type jsMappings struct {
 	// ClassNames is the set of locally scoped class name identifiers discovered
 	// in the input stylesheet.
@@ -59,18 +80,54 @@ type jsMappings struct {
 	// AnimationNames is the set of locally scoped animation name identifiers
 	// discovered in the input stylesheet.
 	AnimationNames map[string]struct{}
+
+	// ContainerNames is the set of locally scoped container names discovered
+	// in the input stylesheet.
+	ContainerNames map[string]struct{}
+
+	// CounterNames is the set of locally scoped counter names discovered in
+	// the input stylesheet.
+	CounterNames map[string]struct{}
+
+	// CounterStyles is the set of locally scoped counter styles defined by
+	// @counter-style rules in the input stylesheet.
+	CounterStyles map[string]struct{}
+
+	// CustomProperties is the set of locally scoped custom properties declared
+	// in the input stylesheet, if custom properties are scoped.
+	CustomProperties map[string]struct{}
 }
 
 func (m *jsMappings) Write(w io.Writer, opts *TransformOpts) error {
 	if _, err := io.WriteString(w, fmt.Sprintf(jsHeaderTemplate, opts.JSModuleName)); err != nil {
 		return err
 	}
-	if err := writeExportedJSMap(w, opts, "exports.classNames = {\n", 1, m.ClassNames); err != nil {
+	if err := writeExportedJSMap(w, opts, "exports.classNames = {\n", 1, m.ClassNames, ""); err != nil {
 		return err
 	}
-	if err := writeExportedJSMap(w, opts, "exports.animationNames = {\n", 1, m.AnimationNames); err != nil {
+	if err := writeExportedJSMap(w, opts, "exports.animationNames = {\n", 1, m.AnimationNames, ""); err != nil {
 		return err
 	}
+	if len(m.ContainerNames) > 0 {
+		if err := writeExportedJSMap(w, opts, "exports.containerNames = {\n", 1, m.ContainerNames, ""); err != nil {
+			return err
+		}
+	}
+	if len(m.CounterNames) > 0 {
+		if err := writeExportedJSMap(w, opts, "exports.counterNames = {\n", 1, m.CounterNames, ""); err != nil {
+			return err
+		}
+	}
+	if len(m.CounterStyles) > 0 {
+		if err := writeExportedJSMap(w, opts, "exports.counterStyles = {\n", 1, m.CounterStyles, ""); err != nil {
+			return err
+		}
+	}
+	if opts.ScopeCustomProperties {
+		if err := writeExportedJSMap(w, opts, "exports.customProperties = {\n", 1, m.CustomProperties, customPropertyPrefix); err != nil {
+			return err
+		}
+	}
 	if _, err := io.WriteString(w, jsFooterTemplate); err != nil {
 		return err
 	}
@@ -79,18 +136,106 @@ func (m *jsMappings) Write(w io.Writer, opts *TransformOpts) error {
 }
 
 func (m *jsMappings) WriteTypeScript(w io.Writer, opts *TransformOpts) error {
-	if err := writeExportedJSMap(w, opts, "export const classNames = {\n", 0, m.ClassNames); err != nil {
+	if err := writeExportedJSMap(w, opts, "export const classNames = {\n", 0, m.ClassNames, ""); err != nil {
 		return err
 	}
-	if err := writeExportedJSMap(w, opts, "export const animationNames = {\n", 0, m.AnimationNames); err != nil {
+	if err := writeExportedJSMap(w, opts, "export const animationNames = {\n", 0, m.AnimationNames, ""); err != nil {
 		return err
 	}
+	if len(m.ContainerNames) > 0 {
+		if err := writeExportedJSMap(w, opts, "export const containerNames = {\n", 0, m.ContainerNames, ""); err != nil {
+			return err
+		}
+	}
+	if len(m.CounterNames) > 0 {
+		if err := writeExportedJSMap(w, opts, "export const counterNames = {\n", 0, m.CounterNames, ""); err != nil {
+			return err
+		}
+	}
+	if len(m.CounterStyles) > 0 {
+		if err := writeExportedJSMap(w, opts, "export const counterStyles = {\n", 0, m.CounterStyles, ""); err != nil {
+			return err
+		}
+	}
+	if opts.ScopeCustomProperties {
+		if err := writeExportedJSMap(w, opts, "export const customProperties = {\n", 0, m.CustomProperties, customPropertyPrefix); err != nil {
+			return err
+		}
+	}
 	if _, err := io.WriteString(w, "export default classNames;\n"); err != nil {
 		return err
 	}
 	return nil
 }
 
+func (m *jsMappings) WriteJSON(w io.Writer, opts *TransformOpts) error {
+	classNames, err := exportedMap(opts, m.ClassNames, "")
+	if err != nil {
+		return err
+	}
+	animationNames, err := exportedMap(opts, m.AnimationNames, "")
+	if err != nil {
+		return err
+	}
+	containerNames, err := exportedMap(opts, m.ContainerNames, "")
+	if err != nil {
+		return err
+	}
+	counterNames, err := exportedMap(opts, m.CounterNames, "")
+	if err != nil {
+		return err
+	}
+	counterStyles, err := exportedMap(opts, m.CounterStyles, "")
+	if err != nil {
+		return err
+	}
+	var customProperties map[string]string
+	if opts.ScopeCustomProperties {
+		if customProperties, err = exportedMap(opts, m.CustomProperties, customPropertyPrefix); err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(&mappings.Module{
+		ClassNames:       classNames,
+		AnimationNames:   animationNames,
+		ContainerNames:   containerNames,
+		CounterNames:     counterNames,
+		CounterStyles:    counterStyles,
+		CustomProperties: customProperties,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+// exportedMap returns a map from exported keys to suffixed identifiers. The
+// given prefix of the identifiers is left out of their keys.
+func exportedMap(opts *TransformOpts, mapping map[string]struct{}, namePrefix string) (map[string]string, error) {
+	if opts.CamelCaseJSKeys {
+		if err := checkForConflicts(mapping, namePrefix); err != nil {
+			return nil, err
+		}
+	}
+	out := make(map[string]string, len(mapping))
+	for c := range mapping {
+		out[exportedKey(opts, c, namePrefix)] = c + string(opts.Suffix)
+	}
+	return out, nil
+}
+
+// exportedKey returns the key of the given identifier in the generated
+// mappings, without the given prefix.
+func exportedKey(opts *TransformOpts, ident, namePrefix string) string {
+	key := strings.TrimPrefix(ident, namePrefix)
+	if opts.CamelCaseJSKeys {
+		key = kebabToCamel(key)
+	}
+	return key
+}
+
 func getIndent(level int) string {
 	out := ""
 	for i := 0; i < level; i++ {
@@ -99,9 +244,9 @@ func getIndent(level int) string {
 	return out
 }
 
-func writeExportedJSMap(w io.Writer, opts *TransformOpts, prefix string, indent int, mapping map[string]struct{}) error {
+func writeExportedJSMap(w io.Writer, opts *TransformOpts, prefix string, indent int, mapping map[string]struct{}, namePrefix string) error {
 	if opts.CamelCaseJSKeys {
-		if err := checkForConflicts(mapping); err != nil {
+		if err := checkForConflicts(mapping, namePrefix); err != nil {
 			return err
 		}
 	}
@@ -110,12 +255,9 @@ func writeExportedJSMap(w io.Writer, opts *TransformOpts, prefix string, indent
 		return err
 	}
 	for _, c := range classes {
-		key := c
-		if opts.CamelCaseJSKeys {
-			key = kebabToCamel(key)
-		}
+		key := exportedKey(opts, c, namePrefix)
 		value := c + string(opts.Suffix)
-		if _, err := io.WriteString(w, fmt.Sprintf("%s%s: '%s',\n", getIndent(indent+1), toJSKeyGrammar(key), value)); err != nil {
+		if _, err := io.WriteString(w, fmt.Sprintf("%s%s: '%s',\n", getIndent(indent+1), toJSKeyGrammar(key), jsStringEscaper.Replace(value))); err != nil {
 			return err
 		}
 	}
@@ -125,10 +267,10 @@ func writeExportedJSMap(w io.Writer, opts *TransformOpts, prefix string, indent
 	return nil
 }
 
-func checkForConflicts(mapping map[string]struct{}) error {
+func checkForConflicts(mapping map[string]struct{}, namePrefix string) error {
 	camelToOriginal := map[string]string{}
 	for k := range mapping {
-		camel := kebabToCamel(k)
+		camel := kebabToCamel(strings.TrimPrefix(k, namePrefix))
 		if conflict := camelToOriginal[camel]; conflict != "" {
 			return fmt.Errorf("class names %q and %q have the same map key representation; rename to avoid conflict", k, conflict)
 		}
@@ -171,13 +313,27 @@ func kebabToCamel(val string) string {
 	return out
 }
 
+// jsStringEscaper escapes the contents of a single-quoted JS string.
+var jsStringEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`)
+
 func toJSKeyGrammar(key string) string {
-	if strings.Contains(key, "-") {
-		return `"` + key + `"`
+	if !isJSIdentifier(key) {
+		return strconv.Quote(key)
 	}
 	return key
 }
 
+// isJSIdentifier returns whether the key can be written as a JS property name
+// without quotes.
+func isJSIdentifier(key string) bool {
+	for i, c := range key {
+		if !(c == '_' || c == '$' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return key != ""
+}
+
 // TransformOpts specifies options for the CSS module transform.
 type TransformOpts struct {
 	// JSWriter is an optional writer for writing JS mappings from the original
@@ -195,6 +351,23 @@ type TransformOpts struct {
 	// CSS identifiers to suffixed ones.
 	TSWriter io.Writer
 
+	// JSONWriter is an optional writer for writing the mappings from the
+	// original CSS identifiers to suffixed ones as a plain JSON object, for
+	// consumption by non-JS tools.
+	JSONWriter io.Writer
+
+	// GoWriter is an optional writer for writing a Go source file which
+	// declares the mappings from the original CSS identifiers to suffixed ones.
+	GoWriter io.Writer
+
+	// GoPackageName is the package name of the generated Go source file.
+	GoPackageName string
+
+	// GoEmbedPath is the path of the transformed stylesheet, relative to the
+	// directory of the generated Go source file. If set, the stylesheet is
+	// embedded in the generated Go package as the CSS variable.
+	GoEmbedPath string
+
 	// Suffix is the suffix to append to all locally scoped identifiers
 	// in the transformed stylesheet. If empty, it will be set to an underscore
 	// followed by a randomly generated string.
@@ -204,6 +377,45 @@ type TransformOpts struct {
 	// keys in the generated JS mappings. For example, the class name "foo-bar"
 	// would be accessed in JS as "fooBar".
 	CamelCaseJSKeys bool
+
+	// Style is the formatting style of the transformed stylesheet. If empty,
+	// StyleExpanded is used.
+	Style Style
+
+	// Indent is the string used for each level of indentation in the
+	// transformed stylesheet. If empty, two spaces are used, and NoIndent
+	// disables indentation. It has no effect on the minified style.
+	Indent string
+
+	// PreserveComments specifies whether to keep comments inside rules, in
+	// their original positions within selectors, declaration values, at-rule
+	// preludes and blocks. Otherwise, only top-level comments are kept. Like
+	// top-level comments, they are dropped by the minified style unless they
+	// are license comments starting with "/*!".
+	PreserveComments bool
+
+	// Warnings is an optional writer for warnings about the input stylesheet,
+	// such as animation names which don't match any @keyframes rule in it.
+	// Each warning is written on its own line.
+	Warnings io.Writer
+
+	// Strict specifies whether to fail the transform on the first problem
+	// which would otherwise be written as a warning.
+	Strict bool
+
+	// ScopeCustomProperties specifies whether to suffix the custom properties
+	// declared in locally scoped rules, along with the var() references to
+	// them, and to include them in the generated mappings.
+	ScopeCustomProperties bool
+
+	// GlobalCustomProperties lists custom properties, such as "--theme-color",
+	// which are never suffixed when ScopeCustomProperties is set.
+	GlobalCustomProperties []string
+
+	// References is an optional function which is called with the URL of each
+	// file referenced by the input stylesheet, in @import rules, url() and
+	// src() functions, and composes declarations, in order of appearance.
+	References func(url string)
 }
 
 // Transform reads a module stylesheet from the given reader, and writes the
@@ -223,15 +435,50 @@ func Transform(r io.Reader, w io.Writer, opts *TransformOpts) error {
 		opts.Suffix = randomSuffix()
 	}
 
-	p := css.NewParser(parse.NewInput(r), false /*=inline*/)
+	input := parse.NewInput(r)
+	if err := input.Err(); err != nil {
+		return fmt.Errorf("failed to read CSS: %s", err)
+	}
+	printerOut := w
+	var edits *sourceEdits
+	if opts.Style == StylePassthrough {
+		// The parser lowercases parts of its input in place, so the edits are
+		// applied to a copy of the source. The printer's output is discarded.
+		edits = &sourceEdits{src: append([]byte(nil), input.Bytes()...)}
+		printerOut = io.Discard
+	}
+	diag := &diagnostics{w: opts.Warnings, strict: opts.Strict, src: input.Bytes()}
+	defs := collectDefinitions(input.Bytes(), opts)
+	refs := &animationRefs{
+		keyframes: defs.keyframes,
+		diag:      diag,
+		suffix:    opts.Suffix,
+	}
+	customProperties := newCustomPropertyRefs(defs, opts)
+	p := css.NewParser(input, false /*=inline*/)
+	p.KeepComments = opts.PreserveComments
+	pr, err := newPrinter(printerOut, opts)
+	if err != nil {
+		return err
+	}
 
 	blockScope := local
-	buf := []byte{}
-	indent := []byte{}
 	js := &jsMappings{
-		ClassNames:     map[string]struct{}{},
-		AnimationNames: map[string]struct{}{},
+		ClassNames:       map[string]struct{}{},
+		AnimationNames:   map[string]struct{}{},
+		ContainerNames:   map[string]struct{}{},
+		CounterNames:     map[string]struct{}{},
+		CounterStyles:    map[string]struct{}{},
+		CustomProperties: map[string]struct{}{},
 	}
+	counters := &counterRefs{
+		styles: defs.counterStyles,
+		suffix: opts.Suffix,
+		js:     js,
+	}
+	// Whether the parser is in the block of a @counter-style rule, whose
+	// descriptors may reference other counter styles.
+	inCounterStyle := false
 	for {
 		// Consume the next token.
 		gt, tt, text := p.Next()
@@ -242,9 +489,17 @@ func Transform(r io.Reader, w io.Writer, opts *TransformOpts) error {
 			fmt.Printf("\033[90m#%s: tt=%s, text=%q, values=%v\033[0m\n", gt, tt, string(text), values)
 		}
 
-		if err == io.EOF {
-			if len(buf) > 0 {
-				return fmt.Errorf("unexpected: unflushed contents %q when seeing EOF", string(buf))
+		// The lexer reports EOF as soon as the last byte is consumed, so the
+		// last grammar may still need to be handled along with EOF.
+		if err == io.EOF && gt == css.ErrorGrammar {
+			pr.finish()
+			if pr.err != nil {
+				return fmt.Errorf("failed to write CSS: %s", pr.err)
+			}
+			if edits != nil {
+				if _, err := w.Write(edits.apply()); err != nil {
+					return fmt.Errorf("failed to write CSS: %s", err)
+				}
 			}
 			// Write mappings file before returning.
 			if opts.JSWriter != nil {
@@ -253,7 +508,20 @@ func Transform(r io.Reader, w io.Writer, opts *TransformOpts) error {
 				}
 			}
 			if opts.TSDeclarationWriter != nil {
-				d := fmt.Sprintf(tsDeclarationTemplate, opts.JSModuleName)
+				var declarations string
+				if len(js.ContainerNames) > 0 {
+					declarations += fmt.Sprintf(tsMapDeclarationTemplate, "containerNames")
+				}
+				if len(js.CounterNames) > 0 {
+					declarations += fmt.Sprintf(tsMapDeclarationTemplate, "counterNames")
+				}
+				if len(js.CounterStyles) > 0 {
+					declarations += fmt.Sprintf(tsMapDeclarationTemplate, "counterStyles")
+				}
+				if opts.ScopeCustomProperties {
+					declarations += fmt.Sprintf(tsMapDeclarationTemplate, "customProperties")
+				}
+				d := fmt.Sprintf(tsDeclarationTemplate, opts.JSModuleName, declarations)
 				if _, err := io.WriteString(opts.TSDeclarationWriter, d); err != nil {
 					return fmt.Errorf("failed to write TS declaration: %s", err)
 				}
@@ -263,6 +531,16 @@ func Transform(r io.Reader, w io.Writer, opts *TransformOpts) error {
 					return fmt.Errorf("failed to write TS: %s", err)
 				}
 			}
+			if opts.JSONWriter != nil {
+				if err := js.WriteJSON(opts.JSONWriter, opts); err != nil {
+					return fmt.Errorf("failed to write JSON: %s", err)
+				}
+			}
+			if opts.GoWriter != nil {
+				if err := js.WriteGo(opts.GoWriter, opts); err != nil {
+					return fmt.Errorf("failed to write Go: %s", err)
+				}
+			}
 			return nil
 		}
 		// Return non-EOF errors immediately.
@@ -273,77 +551,76 @@ func Transform(r io.Reader, w io.Writer, opts *TransformOpts) error {
 			return fmt.Errorf("parse error: %s", err)
 		}
 
-		if gt == css.QualifiedRuleGrammar || gt == css.BeginRulesetGrammar {
-			b, endScope := transformSelector(text, values, opts, js)
-			buf = append(buf, b...)
+		switch gt {
+		case css.QualifiedRuleGrammar, css.BeginRulesetGrammar:
+			selector, endScope := transformSelector(text, values, opts, js)
+			pr.selector(selector)
+			edits.record(values, selector)
 			blockScope = endScope
 			if gt == css.BeginRulesetGrammar {
-				if len(buf) > 0 && buf[len(buf)-1] != ' ' {
-					buf = append(buf, ' ')
-				}
-				buf = append(buf, '{')
-			}
-			if gt == css.QualifiedRuleGrammar {
-				buf = append(buf, ',')
+				pr.beginRuleset()
 			}
-		} else if gt == css.BeginAtRuleGrammar {
-			buf = append(buf, transformAtRule(text, values, opts, js)...)
-			buf = append(buf, ' ', '{')
-		} else {
-			if gt == css.CustomPropertyGrammar {
-				buf = append(buf, text...)
-				buf = append(buf, ':')
-			}
-			if gt == css.DeclarationGrammar {
-				buf = append(buf, text...)
-				buf = append(buf, ':', ' ')
-			}
-			if gt == css.CommentGrammar {
-				buf = append(buf, text...)
+		case css.BeginAtRuleGrammar:
+			inCounterStyle = isCounterStyleRule(string(text))
+			prelude := transformAtRule(text, values, opts, js, customProperties, counters)
+			pr.beginAtRule(text, prelude)
+			edits.record(values, prelude)
+		case css.AtRuleGrammar:
+			if string(text) == "@import" && opts.References != nil {
+				if url, ok := importReference(values); ok {
+					opts.References(url)
+				}
 			}
-
+			prelude := transformAtRule(text, values, opts, js, customProperties, counters)
+			pr.atRule(text, prelude)
+			edits.record(values, prelude)
+		case css.EndAtRuleGrammar, css.EndRulesetGrammar:
+			pr.endBlock()
+			blockScope = local
+			inCounterStyle = false
+		case css.DeclarationGrammar:
 			textStr := string(text)
-
-			if gt == css.DeclarationGrammar && (textStr == "animation" || textStr == "-webkit-animation" || textStr == "-moz-animation") {
-				buf = append(buf, transformAnimationProperty(values, blockScope, opts)...)
-			} else if gt == css.DeclarationGrammar && (textStr == "animation-name" || textStr == "-webkit-animation-name" || textStr == "-moz-animation-name") {
-				buf = append(buf, transformAnimationNameProperty(values, blockScope, opts)...)
-			} else if gt != css.EndAtRuleGrammar && gt != css.EndRulesetGrammar && gt != css.CommentGrammar {
-				for _, val := range p.Values() {
-					buf = append(buf, val.Data...)
+			if opts.References != nil {
+				for _, url := range declarationReferences(textStr, values) {
+					opts.References(url)
 				}
 			}
-			if gt == css.BeginAtRuleGrammar || gt == css.BeginRulesetGrammar {
-				buf = append(buf, '{')
-			} else if gt == css.AtRuleGrammar || gt == css.DeclarationGrammar || gt == css.CustomPropertyGrammar {
-				buf = append(buf, ';')
-			} else if gt == css.EndAtRuleGrammar || gt == css.EndRulesetGrammar {
-				if len(indent) >= 2 {
-					indent = indent[:len(indent)-2]
-				}
-				buf = append(buf, '}')
-				blockScope = local
+			out := values
+			if textStr == "animation" || textStr == "-webkit-animation" || textStr == "-moz-animation" {
+				out = transformAnimationProperty(values, blockScope, refs)
+			} else if textStr == "animation-name" || textStr == "-webkit-animation-name" || textStr == "-moz-animation-name" {
+				out = transformAnimationNameProperty(values, blockScope, refs)
 			}
-		}
-
-		if len(buf) == 1 && buf[0] == '}' && len(indent) == 0 {
-			// Double-newline at top-level rule sets
-			// TODO: Double-newline for nested rulesets, unless they're the last
-			// one in the ruleset.
-			buf = append(buf, '\n')
-		}
-		buf = append(buf, '\n')
-
-		if len(indent) > 0 {
-			if _, err := w.Write(indent); err != nil {
-				return fmt.Errorf("failed to write CSS: %s", err)
+			if textStr == "container" || textStr == "container-name" {
+				out = transformContainerProperty(values, blockScope, opts, js)
+			} else if isPropertyNameListProperty(textStr) {
+				out = transformPropertyNameReferences(values, blockScope, customProperties)
+			} else if isCounterProperty(textStr) {
+				out = transformCounterProperty(values, blockScope, counters)
+			} else if isCounterStyleProperty(textStr) || inCounterStyle && (textStr == "system" || textStr == "fallback") {
+				out = transformCounterStyleReferences(values, blockScope, counters)
 			}
-		}
-		if len(buf) > 0 {
-			if _, err := w.Write(buf); err != nil {
-				return fmt.Errorf("failed to write CSS: %s", err)
-			}
-			buf = nil
+			out = transformCounterFunctions(out, blockScope, counters)
+			out = transformVarReferences(out, blockScope, customProperties)
+			pr.declaration(text, out)
+			edits.record(values, out)
+		case css.CustomPropertyGrammar:
+			name := css.Token{TokenType: css.CustomPropertyNameToken, Data: text, Offset: p.Offset()}
+			scopedName := customProperties.declare(name, blockScope, js)
+			out := transformCustomPropertyValue(values, blockScope, customProperties)
+			pr.customProperty(scopedName.Data, out)
+			edits.record([]css.Token{name}, []css.Token{scopedName})
+			edits.record(values, out)
+		case css.CommentGrammar:
+			pr.comment(text)
+		case css.TokenGrammar:
+			pr.raw(text)
+		}
+		if pr.err != nil {
+			return fmt.Errorf("failed to write CSS: %s", pr.err)
+		}
+		if diag.err != nil {
+			return diag.err
 		}
 
 		if debug {
@@ -356,27 +633,76 @@ func Transform(r io.Reader, w io.Writer, opts *TransformOpts) error {
 			}
 			debugBuf.Reset()
 		}
-
-		if gt == css.BeginAtRuleGrammar || gt == css.BeginRulesetGrammar {
-			indent = append(indent, ' ', ' ')
-		}
 	}
 }
 
 // randomSuffix returns a random suffix to be appended to class name
 // identifiers.
 func randomSuffix() []byte {
+	suffixRandMu.Lock()
+	defer suffixRandMu.Unlock()
 	out := []byte{'_'}
-	s := rand.NewSource(int64(time.Now().UnixNano()))
-	r := rand.New(s)
 	for i := 0; i < randSuffixLength; i++ {
-		index := r.Intn(len(randSuffixChars))
+		index := suffixRand.Intn(len(randSuffixChars))
 		out = append(out, randSuffixChars[index])
 	}
 	return out
 }
 
-func transformSelector(text []byte, values []css.Token, opts *TransformOpts, js *jsMappings) (buf []byte, endScope scopeType) {
+// StableSuffix returns a suffix derived from a hash of the given key, such as
+// a module name. Unlike the default random suffix, it is the same every time
+// the module is transformed, so that outputs are reproducible.
+func StableSuffix(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	out := []byte{'_'}
+	for i := 0; i < randSuffixLength; i++ {
+		index := int(sum[i]) % len(randSuffixChars)
+		out = append(out, randSuffixChars[index])
+	}
+	return out
+}
+
+// withStringSuffix returns a copy of the string token with the suffix
+// appended to its contents.
+func withStringSuffix(val css.Token, suffix []byte) css.Token {
+	end := len(val.Data)
+	if end > 1 && val.Data[end-1] == val.Data[0] {
+		end--
+	}
+	data := make([]byte, 0, len(val.Data)+len(suffix)+1)
+	data = appendSuffix(data, val.Data[:end], suffix)
+	data = append(data, val.Data[end:]...)
+	return css.Token{TokenType: val.TokenType, Data: data, Offset: val.Offset}
+}
+
+// withSuffix returns a copy of the token with the suffix appended to it.
+func withSuffix(val css.Token, suffix []byte) css.Token {
+	data := make([]byte, 0, len(val.Data)+len(suffix)+1)
+	data = appendSuffix(data, val.Data, suffix)
+	return css.Token{TokenType: val.TokenType, Data: data, Offset: val.Offset}
+}
+
+// withNameSuffix returns a copy of the identifier or string token with the
+// suffix appended to the name which it gives.
+func withNameSuffix(val css.Token, suffix []byte) css.Token {
+	if val.TokenType == css.StringToken {
+		return withStringSuffix(val, suffix)
+	}
+	return withSuffix(val, suffix)
+}
+
+// appendSuffix appends the name followed by the suffix to b. If the name ends
+// with a hex escape which would absorb the start of the suffix, a space is
+// added to terminate the escape.
+func appendSuffix(b, name, suffix []byte) []byte {
+	b = append(b, name...)
+	if len(suffix) > 0 && isHexDigit(suffix[0]) && endsWithHexEscape(name) {
+		b = append(b, ' ')
+	}
+	return append(b, suffix...)
+}
+
+func transformSelector(text []byte, values []css.Token, opts *TransformOpts, js *jsMappings) (out []css.Token, endScope scopeType) {
 	scopeMode := local
 	scopeStack := []scopeType{}
 
@@ -432,149 +758,251 @@ func transformSelector(text []byte, values []css.Token, opts *TransformOpts, js
 		}
 
 		if skip == 0 {
-			buf = append(buf, val.Data...)
 			scope := scopeMode
 			if len(scopeStack) > 0 {
 				scope = scopeStack[len(scopeStack)-1]
 			}
 			if isClassName && scope == local {
-				buf = append(buf, opts.Suffix...)
+				out = append(out, withSuffix(val, opts.Suffix))
 				js.ClassNames[string(val.Data)] = struct{}{}
+			} else {
+				out = append(out, val)
 			}
 		} else {
 			skip--
 		}
 		isClassName = (val.TokenType == css.DelimToken && len(val.Data) == 1 && val.Data[0] == '.')
 	}
-	return buf, scopeMode
+	return out, scopeMode
 }
 
-func transformAtRule(text []byte, values []css.Token, opts *TransformOpts, js *jsMappings) (buf []byte) {
-	buf = append(buf, text...)
-	textStr := string(text)
-	if textStr == "@keyframes" || textStr == "@-webkit-keyframes" || textStr == "@-moz-keyframes" {
-		// When using @keyframes :global, this confuses the parser and it doesn't
-		// add whitespace after @keyframes. Add it here.
-		if len(values) > 0 && values[0].TokenType != css.WhitespaceToken {
-			buf = append(buf, ' ')
-		}
-		scope := local
+// transformAtRule returns the prelude of the given at-rule, with locally
+// scoped identifiers suffixed.
+func transformAtRule(text []byte, values []css.Token, opts *TransformOpts, js *jsMappings, customProperties *customPropertyRefs, counters *counterRefs) (out []css.Token) {
+	if isKeyframesRule(string(text)) {
+		name, scope := keyframesName(values)
 		for i, val := range values {
-			if val.TokenType == css.ColonToken && i+1 < len(values) {
-				next := values[i+1]
-				nextStr := string(next.Data)
-				if next.TokenType == css.FunctionToken && nextStr == "global(" || next.TokenType == css.IdentToken && nextStr == "global" {
-					scope = global
-				}
-			}
-			if val.TokenType != css.ColonToken && val.TokenType != css.FunctionToken && val.TokenType != css.RightParenthesisToken {
-				buf = append(buf, val.Data...)
-			}
-			if val.TokenType == css.IdentToken && scope != global {
-				buf = append(buf, opts.Suffix...)
-				js.AnimationNames[string(val.Data)] = struct{}{}
+			if i == name && scope == local {
+				out = append(out, withNameSuffix(val, opts.Suffix))
+				js.AnimationNames[animationName(val)] = struct{}{}
+			} else if i == name || val.TokenType == css.WhitespaceToken || val.TokenType == css.CommentToken {
+				// Anything else is the :global or :local mode around the name.
+				out = append(out, val)
 			}
 		}
-	} else {
-		for _, val := range values {
-			buf = append(buf, val.Data...)
-		}
+		return out
+	}
+	if isContainerRule(string(text)) {
+		return transformContainerPrelude(values, opts, js)
 	}
-	return
+	if isCounterStyleRule(string(text)) {
+		name, end, scope := counterStyleName(values)
+		if name < 0 {
+			return values
+		}
+		out = append(out, values[:skipWhitespace(values, 0)]...)
+		out = append(out, counters.declareStyle(values[name], scope))
+		return append(out, values[end+1:]...)
+	}
+	if isPropertyRule(string(text)) {
+		name, end, scope := propertyName(values)
+		if name < 0 {
+			return values
+		}
+		out = append(out, values[:skipWhitespace(values, 0)]...)
+		out = append(out, customProperties.declare(values[name], scope, js))
+		return append(out, values[end+1:]...)
+	}
+	return values
 }
 
-func transformAnimationProperty(values []css.Token, scope scopeType, opts *TransformOpts) (buf []byte) {
-	if scope == global {
-		for _, val := range values {
-			buf = append(buf, val.Data...)
-		}
-		return
-	}
-	// Reference: https://www.w3.org/TR/css-animations-1/#animation
+// animationKeywords maps the keywords accepted by the animation shorthand to
+// the longhand property which they set, other than animation-name.
+var animationKeywords = map[string]string{
+	"linear":            "easing",
+	"ease":              "easing",
+	"ease-in":           "easing",
+	"ease-out":          "easing",
+	"ease-in-out":       "easing",
+	"step-start":        "easing",
+	"step-end":          "easing",
+	"infinite":          "iteration-count",
+	"normal":            "direction",
+	"reverse":           "direction",
+	"alternate":         "direction",
+	"alternate-reverse": "direction",
+	"none":              "fill-mode",
+	"forwards":          "fill-mode",
+	"backwards":         "fill-mode",
+	"both":              "fill-mode",
+	"running":           "play-state",
+	"paused":            "play-state",
+	"replace":           "composition",
+	"add":               "composition",
+	"accumulate":        "composition",
+	"auto":              "timeline",
+}
 
-	// local scope
-	inFunction := false
-	sawTimingFunction := false
-	sawIterationCount := false
-	sawDirection := false
-	sawFillMode := false
-	sawPlayState := false
+// animationFunctions maps the functions accepted by the animation shorthand
+// to the longhand property which they set.
+var animationFunctions = map[string]string{
+	"cubic-bezier(": "easing",
+	"steps(":        "easing",
+	"linear(":       "easing",
+	"scroll(":       "timeline",
+	"view(":         "timeline",
+}
 
-	for _, val := range values {
-		buf = append(buf, val.Data...)
-		// Parser throws away whitespace after commas; recover it.
-		if val.TokenType == css.CommaToken {
-			buf = append(buf, ' ')
-		}
-		if val.TokenType == css.RightParenthesisToken {
-			inFunction = false
-			continue
-		}
-		if inFunction || val.TokenType == css.WhitespaceToken || val.TokenType == css.DimensionToken {
-			continue
-		}
-		// Consume next value in list
-		if val.TokenType == css.CommaToken {
-			inFunction = false
-			sawTimingFunction = false
-			sawIterationCount = false
-			sawDirection = false
-			sawFillMode = false
-			sawPlayState = false
-			continue
-		}
-		// Consume timing function
-		if val.TokenType == css.FunctionToken {
-			inFunction = true
-			sawTimingFunction = true
-			continue
-		}
-		str := string(val.Data)
-		if !sawTimingFunction && (str == "linear" || str == "ease" || str == "ease-in" || str == "ease-out" ||
-			str == "ease-in-out" || str == "step-start" || str == "step-end") {
-			sawTimingFunction = true
-			continue
-		}
-		if !sawIterationCount && (str == "infinite" || val.TokenType == css.NumberToken) {
-			sawIterationCount = true
-			continue
+// cssWideKeywords are the keywords which every property accepts, and which
+// are never animation names.
+var cssWideKeywords = map[string]bool{
+	"initial":      true,
+	"inherit":      true,
+	"unset":        true,
+	"revert":       true,
+	"revert-layer": true,
+}
+
+// transformAnimationProperty suffixes the animation names in the value of an
+// animation shorthand property which refer to locally scoped keyframes.
+//
+// Reference: https://www.w3.org/TR/css-animations-2/#animation
+//
+// As the spec requires, an identifier is only interpreted as the animation
+// name if it isn't a keyword of another longhand property which wasn't set
+// earlier in the same animation. For example, in "infinite infinite", the
+// second "infinite" is the animation name.
+//
+// A name wrapped in global() or local() is always the animation name, and is
+// scoped accordingly regardless of the scope of the rule.
+func transformAnimationProperty(values []css.Token, scope scopeType, refs *animationRefs) []css.Token {
+	out := make([]css.Token, 0, len(values))
+	// seen is the set of longhand properties set so far by the current
+	// animation in the comma-separated list.
+	seen := map[string]bool{}
+	depth := 0
+	for i := 0; i < len(values); i++ {
+		val := values[i]
+		if depth == 0 {
+			if name, end, nameScope, ok := scopedName(values, i); ok {
+				seen["name"] = true
+				out = append(out, refs.resolveScoped(values[name], nameScope))
+				i = end
+				continue
+			}
 		}
-		if !sawDirection && (str == "normal" || str == "reverse" || str == "alternate" || str == "alternate-reverse") {
-			sawDirection = true
+		out = append(out, val)
+		if depth > 0 {
+			// Function arguments, such as the arguments of var(), are never
+			// animation names.
+			depth += nestingDelta(val)
 			continue
 		}
-		if !sawFillMode && (str == "none" || str == "forwards" || str == "backwards" || str == "both") {
-			sawFillMode = true
-			continue
+		depth += nestingDelta(val)
+		switch val.TokenType {
+		case css.CommaToken:
+			seen = map[string]bool{}
+		case css.FunctionToken:
+			if longhand := animationFunctions[strings.ToLower(string(val.Data))]; longhand != "" {
+				seen[longhand] = true
+			}
+		case css.NumberToken:
+			seen["iteration-count"] = true
+		case css.StringToken:
+			if !seen["name"] {
+				seen["name"] = true
+				if scope == local {
+					out[len(out)-1] = refs.resolve(val)
+				}
+			}
+		case css.IdentToken:
+			ident := strings.ToLower(string(val.Data))
+			if cssWideKeywords[ident] {
+				continue
+			}
+			if strings.HasPrefix(ident, "--") {
+				// Dashed identifiers are timeline names.
+				seen["timeline"] = true
+				continue
+			}
+			if longhand := animationKeywords[ident]; longhand != "" && !seen[longhand] {
+				seen[longhand] = true
+				continue
+			}
+			if !seen["name"] {
+				seen["name"] = true
+				if ident != "none" && scope == local {
+					out[len(out)-1] = refs.resolve(val)
+				}
+			}
 		}
-		if !sawPlayState && (str == "running" || str == "paused") {
-			sawPlayState = true
+	}
+	return out
+}
+
+// transformAnimationNameProperty suffixes the animation names in the value of
+// an animation-name property which refer to locally scoped keyframes, or
+// which are wrapped in local().
+func transformAnimationNameProperty(values []css.Token, scope scopeType, refs *animationRefs) []css.Token {
+	out := make([]css.Token, 0, len(values))
+	for i := 0; i < len(values); i++ {
+		val := values[i]
+		if name, end, nameScope, ok := scopedName(values, i); ok {
+			out = append(out, refs.resolveScoped(values[name], nameScope))
+			i = end
 			continue
 		}
-		// If we see an identifier that can't be parsed as any other property,
-		// interpret it as the animation name and apply the suffix.
-		if val.TokenType == css.IdentToken {
-			buf = append(buf, opts.Suffix...)
+		if scope == local {
+			switch val.TokenType {
+			case css.StringToken:
+				val = refs.resolve(val)
+			case css.IdentToken:
+				ident := strings.ToLower(string(val.Data))
+				if ident != "none" && !cssWideKeywords[ident] {
+					val = refs.resolve(val)
+				}
+			}
 		}
+		out = append(out, val)
 	}
-
-	return
+	return out
 }
 
-func transformAnimationNameProperty(values []css.Token, scope scopeType, opts *TransformOpts) (buf []byte) {
-	if scope == global {
-		for _, val := range values {
-			buf = append(buf, val.Data...)
-		}
-		return
+// scopedName returns the index of the name in a global() or local() function
+// starting at values[i], optionally preceded by a colon as in ":global(fade)",
+// along with the index of the closing parenthesis and the scope given by the
+// function. The name may be an identifier, a string or a custom property.
+func scopedName(values []css.Token, i int) (name, end int, scope scopeType, ok bool) {
+	if values[i].TokenType == css.ColonToken {
+		i++
 	}
-
-	for _, val := range values {
-		buf = append(buf, val.Data...)
-		if val.TokenType == css.IdentToken {
-			buf = append(buf, opts.Suffix...)
-		}
+	if i >= len(values) || values[i].TokenType != css.FunctionToken {
+		return 0, 0, local, false
 	}
+	switch strings.ToLower(string(values[i].Data)) {
+	case "global(":
+		scope = global
+	case "local(":
+		scope = local
+	default:
+		return 0, 0, local, false
+	}
+	j := skipWhitespace(values, i+1)
+	if j >= len(values) || values[j].TokenType != css.IdentToken && values[j].TokenType != css.StringToken && values[j].TokenType != css.CustomPropertyNameToken {
+		return 0, 0, local, false
+	}
+	end = skipWhitespace(values, j+1)
+	if end >= len(values) || values[end].TokenType != css.RightParenthesisToken {
+		return 0, 0, local, false
+	}
+	return j, end, scope, true
+}
 
-	return
+// skipWhitespace returns the index of the first token at or after i which
+// isn't whitespace.
+func skipWhitespace(values []css.Token, i int) int {
+	for i < len(values) && values[i].TokenType == css.WhitespaceToken {
+		i++
+	}
+	return i
 }
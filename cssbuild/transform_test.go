@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -16,10 +17,14 @@ func TestTransform(t *testing.T) {
 	expectedJS := readFileAsString(t, "testdata/expected_output.module.css.js")
 	expectedTSDeclaration := readFileAsString(t, "testdata/expected_output.module.css.d.ts")
 	expectedTSSource := readFileAsString(t, "testdata/expected_output.ts")
+	expectedJSON := readFileAsString(t, "testdata/expected_output.module.css.json")
+	expectedGo := readFileAsString(t, "testdata/expected_output.go")
 	var actual bytes.Buffer
 	var actualJS bytes.Buffer
 	var actualTSDeclaration bytes.Buffer
 	var actualTSSource bytes.Buffer
+	var actualJSON bytes.Buffer
+	var actualGo bytes.Buffer
 
 	err := Transform(strings.NewReader(input), &actual, &TransformOpts{
 		Suffix:              []byte("__SUFFIX__"),
@@ -27,14 +32,718 @@ func TestTransform(t *testing.T) {
 		JSModuleName:        "cssbuild/cssbuild/testdata/expected_output.module.css",
 		TSDeclarationWriter: &actualTSDeclaration,
 		TSWriter:            &actualTSSource,
+		JSONWriter:          &actualJSON,
+		GoWriter:            &actualGo,
+		GoPackageName:       "testdata",
+		GoEmbedPath:         "expected_output.module.css",
 		CamelCaseJSKeys:     true,
 	})
 
 	checkErr(t, err)
-	checkDiff(t, expected, formatCSS(t, actual.String()))
+	checkDiff(t, expected, actual.String())
 	checkDiff(t, expectedJS, actualJS.String())
 	checkDiff(t, expectedTSDeclaration, actualTSDeclaration.String())
 	checkDiff(t, expectedTSSource, actualTSSource.String())
+	checkDiff(t, expectedJSON, actualJSON.String())
+	checkDiff(t, expectedGo, actualGo.String())
+}
+
+func TestTransformMinified(t *testing.T) {
+	input := readFileAsString(t, "testdata/input.module.css")
+	expected := readFileAsString(t, "testdata/expected_output.min.css")
+	var actual bytes.Buffer
+
+	err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+		Suffix: []byte("__SUFFIX__"),
+		Style:  StyleMinified,
+	})
+
+	checkErr(t, err)
+	checkDiff(t, expected, actual.String())
+}
+
+func TestTransformStyles(t *testing.T) {
+	const input = `.a { color: red; } @media print { .b, .c { x: y; z: w; } .d {} }`
+	for _, test := range []struct {
+		style    Style
+		indent   string
+		expected string
+	}{
+		{
+			style: StyleExpanded,
+			expected: `.a_x {
+  color: red;
+}
+
+@media print {
+  .b_x,
+  .c_x {
+    x: y;
+    z: w;
+  }
+
+  .d_x {
+  }
+}
+`,
+		},
+		{
+			style:  StyleExpanded,
+			indent: NoIndent,
+			expected: `.a_x {
+color: red;
+}
+
+@media print {
+.b_x,
+.c_x {
+x: y;
+z: w;
+}
+
+.d_x {
+}
+}
+`,
+		},
+		{
+			style:  StyleExpanded,
+			indent: "\t",
+			expected: `.a_x {
+	color: red;
+}
+
+@media print {
+	.b_x,
+	.c_x {
+		x: y;
+		z: w;
+	}
+
+	.d_x {
+	}
+}
+`,
+		},
+		{
+			style: StyleCompact,
+			expected: `.a_x { color: red; }
+@media print {
+  .b_x, .c_x { x: y; z: w; }
+  .d_x { }
+}
+`,
+		},
+		{
+			style:    StyleMinified,
+			expected: ".a_x{color:red}@media print{.b_x,.c_x{x:y;z:w}.d_x{}}\n",
+		},
+	} {
+		var actual bytes.Buffer
+		err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+			Suffix: []byte("_x"),
+			Style:  test.style,
+			Indent: test.indent,
+		})
+		checkErr(t, err)
+		if actual.String() != test.expected {
+			t.Errorf("style %q, indent %q: got:\n%s\nwant:\n%s", test.style, test.indent, actual.String(), test.expected)
+		}
+	}
+}
+
+func TestPreserveComments(t *testing.T) {
+	const input = `/* top */
+.foo /* sel */ .bar, /* list */ .baz > /* comb */ .qux {
+  /* before */
+  color: /* stylelint-disable */ red;
+  margin: 0 /* @noflip */ 10px;
+  /*! license */
+  animation: /* a */ 1s fade;
+}
+
+@media /* m */ screen and (/* f */ max-width: 100px) {
+  /* in media */
+  .a { b: c }
+}
+`
+	for _, test := range []struct {
+		style    Style
+		expected string
+	}{
+		{
+			style: StyleExpanded,
+			expected: `/* top */
+.foo_x /* sel */ .bar_x,
+/* list */ .baz_x > /* comb */ .qux_x {
+  /* before */
+  color: /* stylelint-disable */ red;
+  margin: 0 /* @noflip */ 10px;
+  /*! license */
+  animation: /* a */ 1s fade;
+}
+
+@media /* m */ screen and (/* f */ max-width: 100px) {
+  /* in media */
+  .a_x {
+    b: c;
+  }
+}
+`,
+		},
+		{
+			style:    StyleMinified,
+			expected: ".foo_x .bar_x,.baz_x>.qux_x{color:red;margin:0 10px;/*! license */animation:1s fade}@media screen and (max-width:100px){.a_x{b:c}}\n",
+		},
+	} {
+		var actual bytes.Buffer
+		err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+			Suffix:           []byte("_x"),
+			Style:            test.style,
+			PreserveComments: true,
+		})
+		checkErr(t, err)
+		if actual.String() != test.expected {
+			t.Errorf("style %q: got:\n%s\nwant:\n%s", test.style, actual.String(), test.expected)
+		}
+	}
+}
+
+func TestCommentsDroppedByDefault(t *testing.T) {
+	const input = `/* top */
+.a /* x */ .b, /* y */ .c { color: /* z */ red; }
+@media /* m */ screen { .d { e: f } }
+@import /* i */ "x.css";
+`
+	const expected = `/* top */
+/* y */
+.a_x .b_x,
+.c_x {
+  color: red;
+}
+
+@media screen {
+  .d_x {
+    e: f;
+  }
+}
+
+@import "x.css";
+`
+	var actual bytes.Buffer
+	err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+		Suffix: []byte("_x"),
+	})
+	checkErr(t, err)
+	checkDiff(t, expected, actual.String())
+}
+
+func TestTransformPassthrough(t *testing.T) {
+	const input = `/* Header */
+.Foo  :global   .bar,.baz>.qux{ COLOR : Red ;/* c */animation-NAME:spin , "quoted" }
+
+@media (max-width:100px){.foo :global{margin:0px}}
+@keyframes :global(fade) {}
+@keyframes spin {}
+`
+	const expected = `/* Header */
+.Foo_x  .bar,.baz_x>.qux_x{ COLOR : Red ;/* c */animation-NAME:spin_x , "quoted" }
+
+@media (max-width:100px){.foo_x {margin:0px}}
+@keyframes fade {}
+@keyframes spin_x {}
+`
+	var actual bytes.Buffer
+	err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+		Suffix: []byte("_x"),
+		Style:  StylePassthrough,
+	})
+	checkErr(t, err)
+	checkDiff(t, expected, actual.String())
+}
+
+func TestTransformAnimation(t *testing.T) {
+	for _, test := range []struct {
+		property, input, expected string
+	}{
+		{"animation", "1s ease-in spin", "1s ease-in spin_x"},
+		{"animation", "spin 1s 2s infinite alternate both paused", "spin_x 1s 2s infinite alternate both paused"},
+		{"animation", "2s infinite infinite", "2s infinite infinite_x"},
+		{"animation", "1s linear linear", "1s linear linear_x"},
+		{"animation", "1s none none", "1s none none"},
+		{"animation", "1s none", "1s none"},
+		{"animation", "1s forwards none", "1s forwards none"},
+		{"animation", "var(--duration) spin", "var(--duration) spin_x"},
+		{"animation", "var(--easing, ease) ease", "var(--easing, ease) ease"},
+		{"animation", "steps(4, jump-start) spin", "steps(4, jump-start) spin_x"},
+		{"animation", "linear(0, 0.5 50%, 1) spin", "linear(0, 0.5 50%, 1) spin_x"},
+		{"animation", "1s spin add", "1s spin_x add"},
+		{"animation", "1s add", "1s add"},
+		{"animation", "1s spin scroll(block nearest)", "1s spin_x scroll(block nearest)"},
+		{"animation", "1s spin --timeline", "1s spin_x --timeline"},
+		{"animation", "1s \"spin\"", "1s \"spin_x\""},
+		{"animation", "1s INFINITE Spin", "1s INFINITE Spin_x"},
+		{"animation", "inherit", "inherit"},
+		{"animation", "1s spin, 2s infinite fade", "1s spin_x, 2s infinite fade_x"},
+		{"animation-name", "spin, none, \"fade\"", "spin_x, none, \"fade_x\""},
+		{"animation-name", "unset", "unset"},
+		{"animation-name", "spin, library-fade", "spin_x, library-fade"},
+		{"animation", "1s global(fade), local(spin) 2s", "1s fade, spin_x 2s"},
+		{"animation", "1s :global(fade) infinite", "1s fade infinite"},
+		{"animation", "global( infinite ) infinite", "infinite infinite"},
+		{"animation", "1s local(\"spin\")", "1s \"spin_x\""},
+		{"animation", "var(--x, global(fade)) spin", "var(--x, global(fade)) spin_x"},
+		{"animation-name", "global(spin), local(fade), spin", "spin, fade_x, spin_x"},
+		{"animation-name", ":local(spin), :global(\"fade\")", "spin_x, \"fade\""},
+	} {
+		// The keyframes are defined after they are referenced.
+		const keyframes = "@keyframes spin {} @keyframes fade {} @keyframes Spin {} @keyframes infinite {} @keyframes linear {}"
+		var actual bytes.Buffer
+		err := Transform(strings.NewReader(fmt.Sprintf(".a { %s: %s; } %s", test.property, test.input, keyframes)), &actual, &TransformOpts{
+			Suffix: []byte("_x"),
+		})
+		checkErr(t, err)
+		expected := fmt.Sprintf(".a_x {\n  %s: %s;\n}\n", test.property, test.expected)
+		if !strings.HasPrefix(actual.String(), expected) {
+			t.Errorf("%s: %s: got %q, want %q", test.property, test.input, actual.String(), expected)
+		}
+	}
+}
+
+func TestScopedAnimationNamesInGlobalRule(t *testing.T) {
+	const input = `:global .a { animation: 1s local(spin), 2s spin; animation-name: local(spin), global(spin) }
+@keyframes spin {}`
+	for _, test := range []struct {
+		style    Style
+		expected string
+	}{
+		{StyleCompact, ".a { animation: 1s spin_x, 2s spin; animation-name: spin_x, spin; }\n@keyframes spin_x { }\n"},
+		{StylePassthrough, ".a { animation: 1s spin_x, 2s spin; animation-name: spin_x, spin }\n@keyframes spin_x {}"},
+	} {
+		var actual bytes.Buffer
+		err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+			Suffix: []byte("_x"),
+			Style:  test.style,
+		})
+		checkErr(t, err)
+		if actual.String() != test.expected {
+			t.Errorf("style %q: got %q, want %q", test.style, actual.String(), test.expected)
+		}
+	}
+}
+
+func TestQuotedAndEscapedKeyframesNames(t *testing.T) {
+	const input = `@keyframes "spin" {}
+@keyframes f\61 de {}
+@keyframes "it's" {}
+@keyframes \31 23 {}
+@keyframes a\31 {}
+.a { animation: 1s spin, 2s "fade"; animation-name: \73pin, fa\64 e, "it\'s", \31 23, a1 }
+`
+	var actual bytes.Buffer
+	var actualJS bytes.Buffer
+	err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+		Suffix:   []byte("0x"),
+		Style:    StyleCompact,
+		TSWriter: &actualJS,
+	})
+	checkErr(t, err)
+	checkDiff(t, `@keyframes "spin0x" { }
+@keyframes f\61 de0x { }
+@keyframes "it's0x" { }
+@keyframes \31 230x { }
+@keyframes a\31 0x { }
+.a0x { animation: 1s spin0x, 2s "fade0x"; animation-name: \73pin0x, fa\64 e0x, "it\'s0x", \31 230x, a10x; }
+`, actual.String())
+	checkDiff(t, `export const classNames = {
+  a: 'a0x',
+};
+export const animationNames = {
+  "123": '1230x',
+  a1: 'a10x',
+  fade: 'fade0x',
+  "it's": 'it\'s0x',
+  spin: 'spin0x',
+};
+export default classNames;
+`, actualJS.String())
+}
+
+func TestReferences(t *testing.T) {
+	const input = `@import "base.css";
+@import url(theme.css) screen;
+@font-face { font-family: x; src: url("fonts/X.woff2") format("woff2"), url(fonts/x\2e woff); }
+.a { composes: b c from "./shared.module.css"; background: url( 'img/a.png' ) no-repeat, src("img/b.png"); }
+.b { composes: c from global; }
+`
+	var actual []string
+	err := Transform(strings.NewReader(input), io.Discard, &TransformOpts{
+		Suffix:     []byte("_x"),
+		References: func(url string) { actual = append(actual, url) },
+	})
+	checkErr(t, err)
+	expected := []string{"base.css", "theme.css", "fonts/X.woff2", "fonts/x.woff", "./shared.module.css", "img/a.png", "img/b.png"}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected references %q, got %q", expected, actual)
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	for _, test := range []struct {
+		input, expected string
+	}{
+		{`spin`, `spin`},
+		{`sp\69n`, `spin`},
+		{`sp\69 n`, `spin`},
+		{`\31 23`, `123`},
+		{`a\.b`, `a.b`},
+		{`\0`, "\uFFFD"},
+		{`\1F600`, "\U0001F600"},
+		{"a\\\nb", `ab`},
+		{`a\`, `a\`},
+	} {
+		if actual := unescape(test.input); actual != test.expected {
+			t.Errorf("unescape(%q) = %q, want %q", test.input, actual, test.expected)
+		}
+	}
+}
+
+func TestScopeCustomProperties(t *testing.T) {
+	const input = `.a { --size: 1px; --theme: red; width: var(--size); color: var(--theme, var( --size)); margin: var(:global(--size)) }
+:global .b { --size: 2px; height: var(--size); padding: var(local(--size)) }
+.c { --gap: calc(var(--size) * 2); top: var(--other) }
+`
+	for _, test := range []struct {
+		style    Style
+		expected string
+	}{
+		{
+			style: StyleCompact,
+			expected: `.a_x { --size_x: 1px; --theme: red; width: var(--size_x); color: var(--theme, var( --size_x)); margin: var(--size); }
+.b { --size: 2px; height: var(--size); padding: var(--size_x); }
+.c_x { --gap_x: calc(var(--size_x) * 2); top: var(--other); }
+`,
+		},
+		{
+			style: StylePassthrough,
+			expected: `.a_x { --size_x: 1px; --theme: red; width: var(--size_x); color: var(--theme, var( --size_x)); margin: var(--size) }
+.b { --size: 2px; height: var(--size); padding: var(--size_x) }
+.c_x { --gap_x: calc(var(--size_x) * 2); top: var(--other) }
+`,
+		},
+	} {
+		var actual bytes.Buffer
+		var actualTS bytes.Buffer
+		var actualJSON bytes.Buffer
+		err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+			Suffix:                 []byte("_x"),
+			Style:                  test.style,
+			ScopeCustomProperties:  true,
+			GlobalCustomProperties: []string{"--theme"},
+			TSWriter:               &actualTS,
+			JSONWriter:             &actualJSON,
+			CamelCaseJSKeys:        true,
+		})
+		checkErr(t, err)
+		checkDiff(t, test.expected, actual.String())
+		checkDiff(t, `export const classNames = {
+  a: 'a_x',
+  c: 'c_x',
+};
+export const animationNames = {
+};
+export const customProperties = {
+  gap: '--gap_x',
+  size: '--size_x',
+};
+export default classNames;
+`, actualTS.String())
+		checkDiff(t, `{
+  "classNames": {
+    "a": "a_x",
+    "c": "c_x"
+  },
+  "animationNames": {},
+  "customProperties": {
+    "gap": "--gap_x",
+    "size": "--size_x"
+  }
+}
+`, actualJSON.String())
+	}
+}
+
+func TestScopePropertyRules(t *testing.T) {
+	const input = `.a { transform: rotate(var(--angle)); color: var(--theme) }
+@property --angle { syntax: '<angle>'; inherits: false; initial-value: 0deg }
+@property :global(--theme) { syntax: '<color>'; inherits: true; initial-value: red }
+.b { transition: --angle 1s ease-in, --theme 2s, opacity 1s; transition-property: --angle, :global(--angle); will-change: --angle, transform }
+:global .c { transition: --angle 1s }
+`
+	for _, test := range []struct {
+		style    Style
+		scope    bool
+		expected string
+	}{
+		{
+			style: StyleCompact,
+			scope: true,
+			expected: `.a_x { transform: rotate(var(--angle_x)); color: var(--theme); }
+@property --angle_x { syntax: '<angle>'; inherits: false; initial-value: 0deg; }
+@property --theme { syntax: '<color>'; inherits: true; initial-value: red; }
+.b_x { transition: --angle_x 1s ease-in, --theme 2s, opacity 1s; transition-property: --angle_x, --angle; will-change: --angle_x, transform; }
+.c { transition: --angle 1s; }
+`,
+		},
+		{
+			style: StylePassthrough,
+			scope: true,
+			expected: `.a_x { transform: rotate(var(--angle_x)); color: var(--theme) }
+@property --angle_x { syntax: '<angle>'; inherits: false; initial-value: 0deg }
+@property --theme { syntax: '<color>'; inherits: true; initial-value: red }
+.b_x { transition: --angle_x 1s ease-in, --theme 2s, opacity 1s; transition-property: --angle_x, --angle; will-change: --angle_x, transform }
+.c { transition: --angle 1s }
+`,
+		},
+		{
+			style: StyleCompact,
+			expected: `.a_x { transform: rotate(var(--angle)); color: var(--theme); }
+@property --angle { syntax: '<angle>'; inherits: false; initial-value: 0deg; }
+@property --theme { syntax: '<color>'; inherits: true; initial-value: red; }
+.b_x { transition: --angle 1s ease-in, --theme 2s, opacity 1s; transition-property: --angle, :global(--angle); will-change: --angle, transform; }
+.c { transition: --angle 1s; }
+`,
+		},
+	} {
+		var actual bytes.Buffer
+		var actualJSON bytes.Buffer
+		err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+			Suffix:                []byte("_x"),
+			Style:                 test.style,
+			ScopeCustomProperties: test.scope,
+			JSONWriter:            &actualJSON,
+		})
+		checkErr(t, err)
+		checkDiff(t, test.expected, actual.String())
+		if test.scope && !strings.Contains(actualJSON.String(), `"angle": "--angle_x"`) {
+			t.Errorf("style %q: @property name missing from mappings:\n%s", test.style, actualJSON.String())
+		}
+	}
+}
+
+func TestScopeContainers(t *testing.T) {
+	const input = `.a { container-name: sidebar :global(page) }
+.b { container: card / inline-size }
+.c :global { container-name: layout; container: none }
+@container sidebar (min-width: 400px) { .d { color: red } }
+@container :global(layout) (min-width: 400px), not (max-width: 100px) { .e { color: red } }
+`
+	for _, test := range []struct {
+		style    Style
+		expected string
+	}{
+		{
+			style: StyleCompact,
+			expected: `.a_x { container-name: sidebar_x page; }
+.b_x { container: card_x/inline-size; }
+.c_x { container-name: layout; container: none; }
+@container sidebar_x (min-width: 400px) {
+  .d_x { color: red; }
+}
+@container layout (min-width: 400px), not (max-width: 100px) {
+  .e_x { color: red; }
+}
+`,
+		},
+		{
+			style: StylePassthrough,
+			expected: `.a_x { container-name: sidebar_x page }
+.b_x { container: card_x / inline-size }
+.c_x { container-name: layout; container: none }
+@container sidebar_x (min-width: 400px) { .d_x { color: red } }
+@container layout (min-width: 400px), not (max-width: 100px) { .e_x { color: red } }
+`,
+		},
+	} {
+		var actual bytes.Buffer
+		var actualJSON bytes.Buffer
+		err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+			Suffix:     []byte("_x"),
+			Style:      test.style,
+			JSONWriter: &actualJSON,
+		})
+		checkErr(t, err)
+		checkDiff(t, test.expected, actual.String())
+		if !strings.Contains(actualJSON.String(), `"containerNames": {
+    "card": "card_x",
+    "sidebar": "sidebar_x"
+  }`) {
+			t.Errorf("style %q: unexpected container names in mappings:\n%s", test.style, actualJSON.String())
+		}
+	}
+}
+
+func TestScopeCounters(t *testing.T) {
+	const input = `@counter-style thumbs { system: cyclic; symbols: "+"; }
+@counter-style more-thumbs { system: extends thumbs; fallback: decimal; }
+@counter-style :global(stars) { system: cyclic; symbols: "*"; }
+ol { counter-reset: item reversed(section) 2 list-item; list-style: inside thumbs; }
+li { counter-increment: item :global(page); content: counter(item, more-thumbs) counters(section, ".", decimal); }
+.a :global { counter-set: item 1; list-style-type: thumbs; content: counter(item, local(thumbs)); }
+`
+	for _, test := range []struct {
+		style    Style
+		expected string
+	}{
+		{
+			style: StyleCompact,
+			expected: `@counter-style thumbs_x { system: cyclic; symbols: "+"; }
+@counter-style more-thumbs_x { system: extends thumbs_x; fallback: decimal; }
+@counter-style stars { system: cyclic; symbols: "*"; }
+ol { counter-reset: item_x reversed(section_x) 2 list-item; list-style: inside thumbs_x; }
+li { counter-increment: item_x page; content: counter(item_x, more-thumbs_x) counters(section_x, ".", decimal); }
+.a_x { counter-set: item 1; list-style-type: thumbs; content: counter(item, thumbs_x); }
+`,
+		},
+		{
+			style: StylePassthrough,
+			expected: `@counter-style thumbs_x { system: cyclic; symbols: "+"; }
+@counter-style more-thumbs_x { system: extends thumbs_x; fallback: decimal; }
+@counter-style stars { system: cyclic; symbols: "*"; }
+ol { counter-reset: item_x reversed(section_x) 2 list-item; list-style: inside thumbs_x; }
+li { counter-increment: item_x page; content: counter(item_x, more-thumbs_x) counters(section_x, ".", decimal); }
+.a_x { counter-set: item 1; list-style-type: thumbs; content: counter(item, thumbs_x); }
+`,
+		},
+	} {
+		var actual bytes.Buffer
+		var actualJSON bytes.Buffer
+		err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+			Suffix:     []byte("_x"),
+			Style:      test.style,
+			JSONWriter: &actualJSON,
+		})
+		checkErr(t, err)
+		checkDiff(t, test.expected, actual.String())
+		if !strings.Contains(actualJSON.String(), `"counterNames": {
+    "item": "item_x",
+    "section": "section_x"
+  },
+  "counterStyles": {
+    "more-thumbs": "more-thumbs_x",
+    "thumbs": "thumbs_x"
+  }`) {
+			t.Errorf("style %q: unexpected counters in mappings:\n%s", test.style, actualJSON.String())
+		}
+	}
+}
+
+func TestUnmatchedAnimationNames(t *testing.T) {
+	const input = `.a {
+  animation: 1s spin, 2s library-fade;
+}
+.b {
+  animation-name: fade, "missing";
+}
+@keyframes spin {}
+@keyframes :global(fade) {}
+`
+	var warnings bytes.Buffer
+	var actual bytes.Buffer
+	err := Transform(strings.NewReader(input), &actual, &TransformOpts{
+		Suffix:   []byte("_x"),
+		Style:    StyleCompact,
+		Warnings: &warnings,
+	})
+	checkErr(t, err)
+	checkDiff(t, `.a_x { animation: 1s spin_x, 2s library-fade; }
+.b_x { animation-name: fade, "missing"; }
+@keyframes spin_x { }
+@keyframes fade { }
+`, actual.String())
+	checkDiff(t, `line 2: animation name "library-fade" does not match any @keyframes rule in the module
+line 5: animation name "missing" does not match any @keyframes rule in the module
+`, warnings.String())
+
+	actual.Reset()
+	warnings.Reset()
+	err = Transform(strings.NewReader(input), &actual, &TransformOpts{
+		Suffix:   []byte("_x"),
+		Warnings: &warnings,
+		Strict:   true,
+	})
+	if err == nil || err.Error() != `line 2: animation name "library-fade" does not match any @keyframes rule in the module` {
+		t.Errorf("got error %v, want an error for the unmatched name", err)
+	}
+	if warnings.Len() > 0 {
+		t.Errorf("got warnings %q in strict mode, want none", warnings.String())
+	}
+}
+
+func TestMinifyValues(t *testing.T) {
+	for _, test := range []struct {
+		property, input, expected string
+	}{
+		{"margin", "0px 0.0em -0rem 10px", "0 0 0 10px"},
+		{"transition-duration", "0s", "0s"},
+		{"width", "calc(0px + 1em)", "calc(0px + 1em)"},
+		{"flex", "1 1 0px", "1 1 0px"},
+		{"color", "#AABBCC", "#ABC"},
+		{"color", "#aabbccdd", "#abcd"},
+		{"color", "#aabbcd", "#aabbcd"},
+		{"font", "12px / 1.5 a , b", "12px/1.5 a,b"},
+		{"color", "red !important", "red!important"},
+	} {
+		var actual bytes.Buffer
+		err := Transform(strings.NewReader(fmt.Sprintf(":global .x { %s: %s; }", test.property, test.input)), &actual, &TransformOpts{Style: StyleMinified})
+		checkErr(t, err)
+		expected := fmt.Sprintf(".x{%s:%s}\n", test.property, test.expected)
+		if actual.String() != expected {
+			t.Errorf("%s: %s: got %q, want %q", test.property, test.input, actual.String(), expected)
+		}
+	}
+}
+
+func TestMinifySelectors(t *testing.T) {
+	for _, test := range []struct {
+		input, expected string
+	}{
+		{":is(.a, .b)", ":is(.a,.b)"},
+		{":where( .a , .b .c )", ":where(.a,.b .c)"},
+		{":not(.a) .b", ":not(.a) .b"},
+		{".a > .b ~ .c", ".a>.b~.c"},
+	} {
+		var actual bytes.Buffer
+		err := Transform(strings.NewReader(fmt.Sprintf(":global %s { x: y; }", test.input)), &actual, &TransformOpts{Style: StyleMinified})
+		checkErr(t, err)
+		expected := fmt.Sprintf("%s{x:y}\n", test.expected)
+		if actual.String() != expected {
+			t.Errorf("%s: got %q, want %q", test.input, actual.String(), expected)
+		}
+	}
+}
+
+func TestConcurrentTransformsUseDistinctSuffixes(t *testing.T) {
+	const n = 32
+	outputs := make([]bytes.Buffer, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := Transform(strings.NewReader(".foo {}"), &outputs[i], &TransformOpts{}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	seen := map[string]bool{}
+	for _, out := range outputs {
+		if seen[out.String()] {
+			t.Fatalf("concurrent transforms generated the same output %q", out.String())
+		}
+		seen[out.String()] = true
+	}
 }
 
 func readFileAsString(t *testing.T, path string) string {
@@ -51,7 +760,8 @@ func checkDiff(t *testing.T, left, right string) {
 	rightPath := writeTmp(t, right)
 	b, err := exec.Command("bash", "-c", fmt.Sprintf(`
 		set -euo pipefail
-		diff -Pdpru %q %q | colordiff | tail -n +3
+		colorize() { if command -v colordiff >/dev/null; then colordiff; else cat; fi; }
+		diff -Pdpru %q %q | colorize | tail -n +3
 	`, leftPath, rightPath)).CombinedOutput()
 	if err, ok := err.(*exec.ExitError); ok {
 		if err.ExitCode() == 0 {
@@ -63,16 +773,6 @@ func checkDiff(t *testing.T, left, right string) {
 	checkErr(t, err)
 }
 
-func formatCSS(t *testing.T, css string) string {
-	cmd := exec.Command("prettier", "--parser", "css")
-	cmd.Stdin = strings.NewReader(css)
-	b, err := cmd.CombinedOutput()
-	if err, ok := err.(*exec.ExitError); ok {
-		t.Fatalf("prettier failed (%s)\n%s", err, string(b))
-	}
-	return string(b)
-}
-
 func writeTmp(t *testing.T, content string) string {
 	f, err := os.CreateTemp("", "")
 	if err != nil {
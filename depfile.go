@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// depfileContents returns a Makefile-style depfile declaring that each of the
+// targets depends on each of the given files. Make and Ninja read these to
+// know when to rebuild the targets.
+func depfileContents(targets []string, deps []string) []byte {
+	var b strings.Builder
+	for i, t := range targets {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(escapeDepfilePath(t))
+	}
+	b.WriteString(":")
+	for _, d := range deps {
+		b.WriteString(" \\\n  ")
+		b.WriteString(escapeDepfilePath(d))
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+func escapeDepfilePath(p string) string {
+	p = filepath.ToSlash(p)
+	p = strings.ReplaceAll(p, "$", "$$")
+	p = strings.ReplaceAll(p, "#", `\#`)
+	p = strings.ReplaceAll(p, " ", `\ `)
+	return p
+}
+
+// localDependencies returns the paths of the existing files referenced by the
+// given URLs from a stylesheet in dir, without duplicates. URLs with a scheme,
+// such as "data:" or "https:", and root-relative URLs are skipped, since they
+// don't refer to files relative to the stylesheet. Missing files are skipped
+// too, since Make fails on dependencies which it has no rule to build.
+func localDependencies(dir string, urls []string) []string {
+	var deps []string
+	seen := map[string]bool{}
+	for _, u := range urls {
+		if i := strings.IndexAny(u, "?#"); i >= 0 {
+			u = u[:i]
+		}
+		if u == "" || strings.HasPrefix(u, "/") {
+			continue
+		}
+		if i := strings.IndexAny(u, ":/"); i >= 0 && u[i] == ':' {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(u); err == nil {
+			u = unescaped
+		}
+		p := filepath.Join(dir, filepath.FromSlash(u))
+		if seen[p] {
+			continue
+		}
+		if fi, err := os.Stat(p); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		seen[p] = true
+		deps = append(deps, p)
+	}
+	return deps
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDepfileContents(t *testing.T) {
+	actual := string(depfileContents([]string{"out/a.css", "out/a.js"}, []string{"src/my styles/a#1.module.css", "src/$b.css"}))
+	expected := "out/a.css out/a.js: \\\n  src/my\\ styles/a\\#1.module.css \\\n  src/$$b.css\n"
+	if actual != expected {
+		t.Fatalf("expected depfile %q, got %q", expected, actual)
+	}
+}
+
+func TestDepfileReferences(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"base.css", "shared.module.css", "img/a b.png"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := filepath.Join(dir, "a.module.css")
+	src := `@import "base.css";
+.a {
+  composes: b from "./shared.module.css";
+  background: url(img/a%20b.png?v=1), url("data:image/png;base64,AAAA"), url(https://example.com/x.png), url(/root.png), url(missing.png);
+}
+.b { background: url('img/a%20b.png'); }
+`
+	if err := os.WriteFile(in, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j := &job{
+		InputPath:    in,
+		OutputPath:   "out/a.css",
+		JSPath:       "out/a.js",
+		DepfilePath:  "out/a.css.d",
+		JSModuleName: "a",
+	}
+	outputs, err := j.transform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := string(outputs[len(outputs)-1].Data.Bytes())
+	expected := string(depfileContents([]string{"out/a.css", "out/a.js"}, []string{
+		in,
+		filepath.Join(dir, "base.css"),
+		filepath.Join(dir, "shared.module.css"),
+		filepath.Join(dir, "img", "a b.png"),
+	}))
+	if actual != expected {
+		t.Fatalf("expected depfile %q, got %q", expected, actual)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bduffany/cssbuild/cssbuild"
+)
+
+// job is a single module stylesheet to transform, along with the paths of its
+// outputs. Empty output paths are not written, and stdioPath refers to stdin
+// or stdout.
+type job struct {
+	InputPath  string
+	OutputPath string
+
+	JSPath            string
+	TSDeclarationPath string
+	TSPath            string
+	JSONPath          string
+	GoPath            string
+	DepfilePath       string
+
+	JSModuleName  string
+	GoPackageName string
+
+	// Opts holds the transform options other than the inputs and outputs.
+	Opts cssbuild.TransformOpts
+
+	// ConfigPath is the path of the config file which the options were read
+	// from, if any. The outputs depend on it as well as on the input.
+	ConfigPath string
+
+	// Suffix overrides the suffix of the transform, if non-empty.
+	Suffix []byte
+
+	// Check specifies whether to compare the outputs with the existing files
+	// instead of writing them.
+	Check bool
+
+	// Warnings holds the warnings about the input stylesheet from the last
+	// run, one per line.
+	Warnings bytes.Buffer
+
+	// References holds the paths of the existing local files referenced by
+	// the input stylesheet in the last run, such as the stylesheets it
+	// imports or composes from.
+	References []string
+}
+
+// output is the contents of one of a job's output files.
+type output struct {
+	Path string
+	Data bytes.Buffer
+}
+
+// run transforms the job's input stylesheet, and writes its outputs.
+func (j *job) run() error {
+	outputs, err := j.transform()
+	if err != nil {
+		return err
+	}
+	if j.Check {
+		return checkOutputs(outputs)
+	}
+	return writeOutputs(outputs)
+}
+
+// transform transforms the job's input stylesheet, and returns its outputs
+// without writing them.
+func (j *job) transform() ([]*output, error) {
+	var outputs []*output
+	buffer := func(path string) io.Writer {
+		if path == "" {
+			return nil
+		}
+		o := &output{Path: path}
+		outputs = append(outputs, o)
+		return &o.Data
+	}
+
+	var in io.Reader = os.Stdin
+	dir := "."
+	if j.InputPath != stdioPath {
+		dir = filepath.Dir(j.InputPath)
+		f, err := os.Open(j.InputPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	opts := j.Opts
+	j.Warnings.Reset()
+	opts.Warnings = &j.Warnings
+	opts.JSModuleName = j.JSModuleName
+	opts.GoPackageName = j.GoPackageName
+	if len(j.Suffix) > 0 {
+		opts.Suffix = j.Suffix
+	}
+	out := buffer(j.OutputPath)
+	opts.JSWriter = buffer(j.JSPath)
+	opts.TSDeclarationWriter = buffer(j.TSDeclarationPath)
+	opts.TSWriter = buffer(j.TSPath)
+	opts.JSONWriter = buffer(j.JSONPath)
+	if j.GoPath != "" {
+		rel, err := filepath.Rel(filepath.Dir(j.GoPath), j.OutputPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.GoEmbedPath = filepath.ToSlash(rel)
+		opts.GoWriter = buffer(j.GoPath)
+	}
+	var refs []string
+	opts.References = func(url string) { refs = append(refs, url) }
+	j.References = nil
+	if err := cssbuild.Transform(in, out, &opts); err != nil {
+		return nil, err
+	}
+	if j.InputPath != stdioPath {
+		j.References = localDependencies(dir, refs)
+	}
+	if j.DepfilePath != "" {
+		var deps []string
+		if j.InputPath != stdioPath {
+			deps = append(deps, j.InputPath)
+		}
+		if j.ConfigPath != "" {
+			deps = append(deps, j.ConfigPath)
+		}
+		deps = append(deps, j.References...)
+		var targets []string
+		for _, o := range outputs {
+			if o.Path != stdioPath {
+				targets = append(targets, o.Path)
+			}
+		}
+		buffer(j.DepfilePath).Write(depfileContents(targets, deps))
+	}
+	return outputs, nil
+}
+
+// writeWarnings writes the warnings from the last run to w, prefixed with the
+// input path.
+func (j *job) writeWarnings(w io.Writer) {
+	for _, line := range strings.Split(strings.TrimSuffix(j.Warnings.String(), "\n"), "\n") {
+		if line != "" {
+			fmt.Fprintf(w, "warning: %s: %s\n", j.InputPath, line)
+		}
+	}
+}
+
+// writeOutputs writes each output to a temporary file next to its path, and
+// only renames them into place once all of them were written, so that each
+// output is replaced atomically. If any output fails to be written or renamed
+// into place, the outputs which were already replaced are restored from
+// backups, so the existing files are left untouched.
+func writeOutputs(outputs []*output) (err error) {
+	var tmpPaths []string
+	var replaced []replacement
+	defer func() {
+		for _, p := range tmpPaths {
+			os.Remove(p)
+		}
+		if err != nil {
+			restore(replaced)
+		}
+		for _, r := range replaced {
+			if r.backup != "" {
+				os.Remove(r.backup)
+			}
+		}
+	}()
+	var files []*output
+	for _, o := range outputs {
+		if o.Path == stdioPath {
+			continue
+		}
+		tmpPath, err := writeTemp(o)
+		if err != nil {
+			return err
+		}
+		tmpPaths = append(tmpPaths, tmpPath)
+		files = append(files, o)
+	}
+	for i, o := range files {
+		backup, err := backUp(o.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmpPaths[i], o.Path); err != nil {
+			if backup != "" {
+				os.Remove(backup)
+			}
+			return err
+		}
+		replaced = append(replaced, replacement{path: o.Path, backup: backup})
+	}
+	for _, o := range outputs {
+		if o.Path == stdioPath {
+			if _, err := os.Stdout.Write(o.Data.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replacement is an output file which was replaced by writeOutputs.
+type replacement struct {
+	path string
+	// backup is the path of a copy of the previous file, or empty if there
+	// was no previous file.
+	backup string
+}
+
+// backUp makes a copy of the file at path, if any, without moving it, and
+// returns the path of the copy, which is next to it. The copy is a hard link
+// if possible, so a symlink is copied as a symlink rather than as its target.
+func backUp(path string) (string, error) {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) || err == nil && fi.IsDir() {
+		// Renaming the new file over a directory fails, so it doesn't need
+		// to be restored.
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.bak")
+	if err != nil {
+		return "", err
+	}
+	backup := f.Name()
+	f.Close()
+	if err := os.Remove(backup); err != nil {
+		return "", err
+	}
+	if err := os.Link(path, backup); err == nil {
+		return backup, nil
+	}
+	if err := copyFile(path, backup, fi); err != nil {
+		os.Remove(backup)
+		return "", err
+	}
+	return backup, nil
+}
+
+// copyFile copies the regular file or symlink at src, whose info is fi, to
+// the new path dst.
+func copyFile(src, dst string, fi os.FileInfo) error {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s: can't back up a file of type %s", src, fi.Mode().Type())
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, fi.Mode().Perm())
+}
+
+// restore undoes the given replacements, in reverse order, by renaming the
+// backups of the previous files into place, or removing the new files which
+// had no previous file.
+func restore(replaced []replacement) {
+	for i := len(replaced) - 1; i >= 0; i-- {
+		r := replaced[i]
+		if r.backup != "" {
+			os.Rename(r.backup, r.path)
+		} else {
+			os.Remove(r.path)
+		}
+	}
+}
+
+// writeTemp writes the output to a new temporary file in the directory of its
+// path, and returns the path of the temporary file.
+func writeTemp(o *output) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(o.Path), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(o.Path), "."+filepath.Base(o.Path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(o.Data.Bytes())
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// checkOutputs returns an error summarizing the differences if any of the
+// given outputs differ from the existing files.
+func checkOutputs(outputs []*output) error {
+	var stale []string
+	for _, o := range outputs {
+		existing, err := os.ReadFile(o.Path)
+		if os.IsNotExist(err) {
+			stale = append(stale, fmt.Sprintf("%s: missing", o.Path))
+			continue
+		} else if err != nil {
+			return err
+		}
+		if line := firstDifferentLine(existing, o.Data.Bytes()); line > 0 {
+			stale = append(stale, fmt.Sprintf("%s: out of date (first difference at line %d)", o.Path, line))
+		}
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("outputs are not up to date:\n  %s", strings.Join(stale, "\n  "))
+	}
+	return nil
+}
+
+// firstDifferentLine returns the 1-based number of the first line that
+// differs between a and b, or 0 if they are equal.
+func firstDifferentLine(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return 0
+	}
+	aLines := bytes.Split(a, []byte("\n"))
+	bLines := bytes.Split(b, []byte("\n"))
+	for i := 0; i < len(aLines) && i < len(bLines); i++ {
+		if !bytes.Equal(aLines[i], bLines[i]) {
+			return i + 1
+		}
+	}
+	if len(aLines) < len(bLines) {
+		return len(aLines) + 1
+	}
+	return len(bLines) + 1
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bduffany/cssbuild/cssbuild"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.module.css")
+	if err := os.WriteFile(in, []byte(".foo { color: red; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j := &job{
+		InputPath:    in,
+		OutputPath:   filepath.Join(dir, "a.css"),
+		JSPath:       filepath.Join(dir, "a.js"),
+		JSModuleName: "a",
+		Suffix:       cssbuild.StableSuffix("a"),
+	}
+
+	j.Check = true
+	if err := j.run(); err == nil || !strings.Contains(err.Error(), "a.css: missing") {
+		t.Fatalf("expected missing output error, got %v", err)
+	}
+	j.Check = false
+	if err := j.run(); err != nil {
+		t.Fatal(err)
+	}
+	j.Check = true
+	if err := j.run(); err != nil {
+		t.Fatalf("expected outputs to be up to date, got %s", err)
+	}
+
+	if err := os.WriteFile(in, []byte(".foo { color: red; }\n.bar { color: blue; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.run(); err == nil || !strings.Contains(err.Error(), "a.css: out of date (first difference at line 5)") {
+		t.Fatalf("expected out of date error, got %v", err)
+	}
+}
+
+func TestFailedTransformLeavesOutputsUntouched(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.module.css")
+	if err := os.WriteFile(in, []byte(".foo { color: red; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j := &job{
+		InputPath:    in,
+		OutputPath:   filepath.Join(dir, "out", "a.css"),
+		JSPath:       filepath.Join(dir, "out", "a.js"),
+		JSModuleName: "a",
+	}
+	if err := j.run(); err != nil {
+		t.Fatal(err)
+	}
+	css, err := os.ReadFile(j.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(in, []byte(".foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.run(); err == nil {
+		t.Fatal("expected parse error")
+	}
+	actual, err := os.ReadFile(j.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != string(css) {
+		t.Fatalf("output was modified by failed transform: %q", string(actual))
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected only the 2 outputs in the output directory, got %d entries", len(entries))
+	}
+}
+
+func TestFailedRenameRestoresOutputs(t *testing.T) {
+	dir := t.TempDir()
+	css := filepath.Join(dir, "a.css")
+	if err := os.WriteFile(css, []byte("old css"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The JS output can't be renamed into place over a non-empty directory,
+	// after the CSS and TS declaration outputs were already renamed.
+	js := filepath.Join(dir, "a.js")
+	if err := os.MkdirAll(filepath.Join(js, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	outputs := []*output{{Path: css}, {Path: filepath.Join(dir, "a.d.ts")}, {Path: js}}
+	for _, o := range outputs {
+		o.Data.WriteString("new")
+	}
+
+	if err := writeOutputs(outputs); err == nil {
+		t.Fatal("expected rename error")
+	}
+
+	actual, err := os.ReadFile(css)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != "old css" {
+		t.Fatalf("expected previous output to be restored, got %q", string(actual))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, " ") != "a.css a.js" {
+		t.Fatalf("expected only the previous files to be left, got %q", names)
+	}
+}
+
+func TestFailedRenameRestoresSymlinkedOutputs(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.css")
+	if err := os.WriteFile(target, []byte("old css"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	css := filepath.Join(dir, "a.css")
+	if err := os.Symlink("target.css", css); err != nil {
+		t.Fatal(err)
+	}
+	js := filepath.Join(dir, "a.js")
+	if err := os.MkdirAll(filepath.Join(js, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	outputs := []*output{{Path: css}, {Path: js}}
+	for _, o := range outputs {
+		o.Data.WriteString("new")
+	}
+
+	if err := writeOutputs(outputs); err == nil {
+		t.Fatal("expected rename error")
+	}
+
+	link, err := os.Readlink(css)
+	if err != nil {
+		t.Fatalf("expected symlink to be restored: %s", err)
+	}
+	if link != "target.css" {
+		t.Fatalf("expected symlink to target.css, got %q", link)
+	}
+	actual, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != "old css" {
+		t.Fatalf("expected symlink target to be untouched, got %q", string(actual))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, " ") != "a.css a.js target.css" {
+		t.Fatalf("expected only the previous files to be left, got %q", names)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.module.css")
+	if err := os.WriteFile(in, []byte(".foo { animation: 1s spin; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j := &job{
+		InputPath:  in,
+		OutputPath: filepath.Join(dir, "a.css"),
+	}
+	for i := 0; i < 2; i++ {
+		if err := j.run(); err != nil {
+			t.Fatal(err)
+		}
+		var warnings strings.Builder
+		j.writeWarnings(&warnings)
+		expected := "warning: " + in + ": line 1: animation name \"spin\" does not match any @keyframes rule in the module\n"
+		if warnings.String() != expected {
+			t.Fatalf("run %d: got warnings %q, want %q", i, warnings.String(), expected)
+		}
+	}
+
+	j.Opts.Strict = true
+	if err := j.run(); err == nil || !strings.Contains(err.Error(), "line 1: animation name \"spin\"") {
+		t.Fatalf("expected unmatched animation name error, got %v", err)
+	}
+}
+
+func TestStrictErrorIncludesInputPath(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.module.css")
+	if err := os.WriteFile(in, []byte(".foo { animation: 1s spin; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stderr strings.Builder
+	if code := run([]string{"-in", in, "-out", filepath.Join(dir, "a.css"), "-js_module_name", "a", "-strict"}, &stderr); code == 0 {
+		t.Fatal("expected a non-zero exit code")
+	}
+	expected := "fatal: " + in + ": line 1: animation name \"spin\" does not match any @keyframes rule in the module\n"
+	if stderr.String() != expected {
+		t.Fatalf("got %q, want %q", stderr.String(), expected)
+	}
+}
+
+func TestIndentFlag(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.module.css")
+	if err := os.WriteFile(in, []byte(":global .foo { color: red; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		indent   string
+		expected string
+	}{
+		{"0", ".foo {\ncolor: red;\n}\n"},
+		{"2", ".foo {\n  color: red;\n}\n"},
+		{"tab", ".foo {\n\tcolor: red;\n}\n"},
+	} {
+		out := filepath.Join(dir, "a.css")
+		var stderr strings.Builder
+		if code := run([]string{"-in", in, "-out", out, "-js_module_name", "a", "-indent", test.indent}, &stderr); code != 0 {
+			t.Fatalf("-indent %s: exit code %d: %s", test.indent, code, stderr.String())
+		}
+		actual, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != test.expected {
+			t.Fatalf("-indent %s: expected %q, got %q", test.indent, test.expected, string(actual))
+		}
+	}
+}
+
+func TestMappingOutputs(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected string
+		err      string
+	}{
+		{
+			args:     []string{"-js_module_name", "a"},
+			expected: "a.css a.module.css a.module.css.d.ts a.module.css.js",
+		},
+		{
+			args:     []string{"-js_module_name", "a", "-js_out", "a.js"},
+			expected: "a.css a.js a.module.css",
+		},
+		{
+			args:     []string{"-js_module_name", "a", "-js_out", "a.js", "-ts_declaration_out", "a.d.ts"},
+			expected: "a.css a.d.ts a.js a.module.css",
+		},
+		{
+			args:     []string{"-ts_out", "a.ts"},
+			expected: "a.css a.module.css a.ts",
+		},
+		{
+			args:     []string{"-json_out", "a.json"},
+			expected: "a.css a.json a.module.css",
+		},
+		{
+			args:     []string{"-go_out", "a.go", "-go_package", "a"},
+			expected: "a.css a.go a.module.css",
+		},
+		{
+			args:     []string{"-js_module_name", "a", "-js_out", "a.js", "-ts_out", "a.ts", "-json_out", "a.json"},
+			expected: "a.css a.js a.json a.module.css a.ts",
+		},
+		{
+			args: []string{"-js_out", "a.js"},
+			err:  "missing JS module name",
+		},
+		{
+			args: []string{"-json_out", "a.json", "-ts_declaration_out", "a.d.ts"},
+			err:  "missing JS module name",
+		},
+	} {
+		dir := t.TempDir()
+		in := filepath.Join(dir, "a.module.css")
+		if err := os.WriteFile(in, []byte(".foo { color: red; }\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args := []string{"-in", in, "-out", filepath.Join(dir, "a.css")}
+		for i, arg := range test.args {
+			if i > 0 && strings.HasSuffix(test.args[i-1], "_out") {
+				arg = filepath.Join(dir, arg)
+			}
+			args = append(args, arg)
+		}
+		var stderr strings.Builder
+		code := run(args, &stderr)
+		if test.err != "" {
+			if code == 0 || !strings.Contains(stderr.String(), test.err) {
+				t.Fatalf("%q: expected error %q, got exit code %d: %s", test.args, test.err, code, stderr.String())
+			}
+			continue
+		}
+		if code != 0 {
+			t.Fatalf("%q: exit code %d: %s", test.args, code, stderr.String())
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if strings.Join(names, " ") != test.expected {
+			t.Fatalf("%q: expected files %q, got %q", test.args, test.expected, names)
+		}
+	}
+}
@@ -6,100 +6,437 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bduffany/cssbuild/cssbuild"
 )
 
-var (
-	inputPath  = flag.String("in", "", "Input file path")
-	outputPath = flag.String("out", "", "Output file path")
+// stdioPath is the input or output path that refers to stdin or stdout.
+const stdioPath = "-"
 
-	jsModuleName      = flag.String("js_module_name", "", "JS module name. Required.")
-	jsOutputPath      = flag.String("js_out", "", "JS mapping output path. By default, it will be placed next to the output file, with the same basename as the input path.")
-	tsDeclarationPath = flag.String("ts_declaration_out", "", "TS declaration output path (*.d.ts). By default, it will be the same as the JS output path, with the \".js\" suffix replaced by \".d.ts\"")
-	tsPath            = flag.String("ts_out", "", "TS mapping output path.")
-	camelCaseJSKeys   = flag.Bool("camel_case_js_keys", false, "Whether to convert kebab-case class names in the stylesheet to camelCase in the generated JS.")
-)
+// options holds the flags of a single cssbuild invocation.
+type options struct {
+	inputPath  string
+	outputPath string
+
+	jsModuleName      string
+	jsOutputPath      string
+	tsDeclarationPath string
+	tsPath            string
+	jsonPath          string
+	goPath            string
+	goPackage         string
+	depfilePath       string
+	camelCaseJSKeys   bool
+	stableSuffix      bool
+	style             string
+	indent            string
+	minify            bool
+	preserveComments  bool
+	strict            bool
+
+	scopeCustomProperties  bool
+	globalCustomProperties string
+
+	check         bool
+	watch         bool
+	watchInterval time.Duration
+
+	outputDir      string
+	rootDir        string
+	jsModulePrefix string
+	parallelism    int
+	emit           string
+
+	persistentWorker bool
+
+	configPath string
+	config     *config
+
+	// flags is the flag set that the options are parsed from.
+	flags *flag.FlagSet
+
+	// args are the command line arguments that the options are parsed from.
+	args []string
+
+	// setOnCommandLine is the set of flags which were set by args, which take
+	// precedence over the config file.
+	setOnCommandLine map[string]bool
+}
+
+// newOptions returns options bound to a new flag set, which must be parsed
+// before the options are used.
+func newOptions(output io.Writer) *options {
+	o := &options{}
+	fs := flag.NewFlagSet("cssbuild", flag.ContinueOnError)
+	fs.SetOutput(output)
+	o.flags = fs
+
+	fs.StringVar(&o.inputPath, "in", "", "Input file path, or \"-\" to read from stdin.")
+	fs.StringVar(&o.outputPath, "out", "", "Output file path, or \"-\" to write to stdout. When reading from stdin or writing to stdout, the mapping output paths must be specified explicitly.")
+
+	fs.StringVar(&o.jsModuleName, "js_module_name", "", "JS module name. Required if the JS or TS declaration output is written.")
+	fs.StringVar(&o.jsOutputPath, "js_out", "", "JS mapping output path. If no mapping output path is set (-js_out, -ts_out, -json_out or -go_out), the JS and TS declaration outputs are placed next to the output file, with the same basename as the input path.")
+	fs.StringVar(&o.tsDeclarationPath, "ts_declaration_out", "", "TS declaration output path (*.d.ts). If no mapping output path is set, it defaults to the default JS output path, with the \".js\" suffix replaced by \".d.ts\". Otherwise, it is only written if set.")
+	fs.StringVar(&o.tsPath, "ts_out", "", "TS mapping output path. May be combined with any of the other mapping outputs.")
+	fs.StringVar(&o.jsonPath, "json_out", "", "JSON mapping output path. May be combined with any of the other mapping outputs.")
+	fs.StringVar(&o.goPath, "go_out", "", "Go mapping output path. The generated Go file embeds the output CSS file, so it must be in the same directory as the output CSS file or a parent directory.")
+	fs.StringVar(&o.goPackage, "go_package", "", "Package name of the generated Go file. Required if -go_out is set.")
+	fs.StringVar(&o.depfilePath, "depfile", "", "Makefile-style depfile output path, for use by Make and Ninja. It declares that every output depends on the input, and on the existing local files which the input references with @import, url(), src() or composes.")
+	fs.BoolVar(&o.camelCaseJSKeys, "camel_case_js_keys", false, "Whether to convert kebab-case class names in the stylesheet to camelCase in the generated JS.")
+
+	fs.StringVar(&o.style, "style", string(cssbuild.StyleExpanded), "Formatting style of the output CSS: \"expanded\" (one declaration per line), \"compact\" (one rule per line) \"minified\" (no insignificant whitespace, comments other than /*! license comments, or final semicolons, and with zero lengths and hex colors shortened where safe) or \"passthrough\" (the input copied byte-for-byte, other than suffixes and the removal of :global and :local).")
+	fs.StringVar(&o.indent, "indent", "2", "Indentation of the output CSS: a number of spaces, or \"tab\".")
+	fs.BoolVar(&o.minify, "minify", false, "Shorthand for -style=minified.")
+	fs.BoolVar(&o.preserveComments, "preserve_comments", false, "Keep comments inside rules, in their original positions within selectors, declaration values, at-rule preludes and blocks. By default, only top-level comments are kept.")
+	fs.BoolVar(&o.scopeCustomProperties, "scope_custom_properties", false, "Suffix the custom properties declared in locally scoped rules, along with the var() references to them, and include them in the generated mappings.")
+	fs.StringVar(&o.globalCustomProperties, "global_custom_properties", "", "Comma-separated list of custom properties, such as \"--theme-color\", which are never suffixed by -scope_custom_properties.")
+	fs.BoolVar(&o.strict, "strict", false, "Fail on problems with the input which are otherwise reported as warnings, such as animation names which don't match any @keyframes rule in the module.")
+
+	fs.BoolVar(&o.stableSuffix, "stable_suffix", false, "Derive the suffix of locally scoped identifiers from a hash of the JS module name (or of the input path, if there is no JS module name), instead of generating a random suffix. This makes the outputs reproducible.")
+
+	fs.BoolVar(&o.check, "check", false, "Check that the existing outputs are up to date, without writing anything. Exits with a non-zero code and a summary of the differences if they are not. Requires -stable_suffix.")
+	fs.BoolVar(&o.watch, "watch", false, "Keep running, and re-transform inputs whenever they or the local files they reference change.")
+	fs.DurationVar(&o.watchInterval, "watch_interval", 200*time.Millisecond, "How often to check the inputs for changes in -watch mode.")
+
+	fs.StringVar(&o.outputDir, "out_dir", "", "Output directory for batch mode. In batch mode, the input paths or glob patterns (which may use \"**\" to match any number of directories) are given as positional arguments, and the outputs for each input are written under this directory, mirroring the input's path relative to -root.")
+	fs.StringVar(&o.rootDir, "root", ".", "Batch mode only: directory which all inputs are relative to.")
+	fs.StringVar(&o.jsModulePrefix, "js_module_prefix", "", "Batch mode only: prefix of the JS module names, which are derived from each input's path relative to -root.")
+	fs.IntVar(&o.parallelism, "j", 0, "Batch mode only: maximum number of files to transform concurrently. Defaults to GOMAXPROCS.")
+	fs.StringVar(&o.emit, "emit", "js,d.ts", "Batch mode only: comma-separated list of mapping outputs to write next to each output CSS file. Supported values are \"js\", \"d.ts\", \"ts\", \"json\" and \"d\" (depfile).")
+
+	fs.StringVar(&o.configPath, "config", "", "Path of a JSON config file, whose keys are flag names, and whose values are used for flags not set on the command line. An \"overrides\" list may set per-file flags for the inputs matching its \"files\" glob patterns. Defaults to \""+defaultConfigPath+"\" in the working directory, if it exists.")
+
+	fs.BoolVar(&o.persistentWorker, "persistent_worker", false, "Run as a Bazel persistent worker, reading JSON work requests from stdin and writing responses to stdout. Any other command line arguments are prepended to the arguments of each work request.")
+	return o
+}
+
+// parse parses the given command line arguments.
+func (o *options) parse(args []string) error {
+	if err := o.flags.Parse(args); err != nil {
+		return err
+	}
+	o.args = args
+	o.setOnCommandLine = map[string]bool{}
+	o.flags.Visit(func(f *flag.Flag) {
+		o.setOnCommandLine[f.Name] = true
+	})
+	return nil
+}
 
 func main() {
-	flag.Parse()
-	if err := validateFlags(); err != nil {
-		io.WriteString(os.Stderr, fmt.Sprintf("%s\n", err))
-		flag.Usage()
+	args := os.Args[1:]
+	o := newOptions(os.Stderr)
+	if err := o.parse(args); err != nil {
 		os.Exit(1)
 	}
-	in, err := os.Open(*inputPath)
-	if err != nil {
-		fatal(err)
+	if o.persistentWorker {
+		if err := runWorker(os.Stdin, os.Stdout, withoutWorkerFlag(args)); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	os.Exit(run(args, os.Stderr))
+}
+
+// run runs a single invocation with the given command line arguments, and
+// returns its exit code. Diagnostics are written to stderr.
+func run(args []string, stderr io.Writer) int {
+	o := newOptions(stderr)
+	if err := o.parse(args); err != nil {
+		return 1
+	}
+	if err := o.loadConfig(); err != nil {
+		io.WriteString(stderr, "fatal: "+err.Error()+"\n")
+		return 1
+	}
+	if err := o.validate(); err != nil {
+		io.WriteString(stderr, fmt.Sprintf("%s\n", err))
+		o.flags.Usage()
+		return 1
+	}
+	if o.watch {
+		// The options are reloaded on every poll, so that changes to the
+		// config file are picked up.
+		listJobs := func() ([]*job, error) {
+			o, err := o.reload()
+			if err != nil {
+				return nil, err
+			}
+			if o.outputDir != "" {
+				return o.batchJobs()
+			}
+			j, err := o.singleJob()
+			if err != nil {
+				return nil, err
+			}
+			return []*job{j}, nil
+		}
+		watch(listJobs, o, stderr, nil)
+	}
+	if o.outputDir != "" {
+		return runBatch(o, stderr)
+	}
+	j, err := o.singleJob()
+	if err == nil {
+		// Errors about the input are prefixed with its path, like its
+		// warnings and the errors in batch mode.
+		if err = j.run(); err != nil {
+			err = fmt.Errorf("%s: %s", j.InputPath, err)
+		}
+		j.writeWarnings(stderr)
 	}
-	out, err := os.Create(*outputPath)
 	if err != nil {
-		fatal(err)
+		io.WriteString(stderr, "fatal: "+err.Error()+"\n")
+		return 1
 	}
-	var js, tsd, ts io.WriteCloser
+	return 0
+}
 
-	if *tsPath == "" {
-		jsPath := *jsOutputPath
-		if jsPath == "" {
-			jsOutDir := path.Dir(*outputPath)
-			inputCSSBase := path.Base(*inputPath)
-			jsPath = path.Join(jsOutDir, inputCSSBase+".js")
+// reload returns the options given by the same command line arguments, with
+// the config file read again.
+func (o *options) reload() (*options, error) {
+	ro := newOptions(o.flags.Output())
+	if err := ro.parse(o.args); err != nil {
+		return nil, err
+	}
+	if err := ro.loadConfig(); err != nil {
+		return nil, err
+	}
+	if err := ro.validate(); err != nil {
+		return nil, err
+	}
+	return ro, nil
+}
+
+// singleJob returns the job for the input and outputs given by flags.
+func (o *options) singleJob() (*job, error) {
+	o, err := o.forFile(o.inputPath)
+	if err != nil {
+		return nil, err
+	}
+	j := &job{
+		InputPath:         o.inputPath,
+		OutputPath:        o.outputPath,
+		JSPath:            o.jsOutputPath,
+		TSDeclarationPath: o.tsDeclarationPath,
+		TSPath:            o.tsPath,
+		JSONPath:          o.jsonPath,
+		GoPath:            o.goPath,
+		DepfilePath:       o.depfilePath,
+		JSModuleName:      o.jsModuleName,
+		GoPackageName:     o.goPackage,
+	}
+	// Each output is independent, so any combination of them can be written
+	// from a single transform. The JS and d.ts outputs are only written to
+	// their default paths if no mapping output was requested, and the default
+	// paths can be derived because neither stdin nor stdout is used.
+	if o.defaultsMappingOutputs() {
+		jsOutDir := path.Dir(j.OutputPath)
+		inputCSSBase := path.Base(j.InputPath)
+		j.JSPath = path.Join(jsOutDir, inputCSSBase+".js")
+		if j.TSDeclarationPath == "" {
+			j.TSDeclarationPath = strings.TrimSuffix(j.JSPath, ".js") + ".d.ts"
 		}
-		js, err = os.Create(jsPath)
-		if err != nil {
-			fatal(err)
+	}
+	if err := o.configureJob(j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// defaultsMappingOutputs returns whether the JS and d.ts outputs of a single
+// file are written to their default paths, since none of the other mapping
+// output paths is set.
+func (o *options) defaultsMappingOutputs() bool {
+	return o.jsOutputPath == "" && o.tsPath == "" && o.jsonPath == "" && o.goPath == "" && !o.usesStdio()
+}
+
+// configureJob applies the flags which are common to every job.
+func (o *options) configureJob(j *job) error {
+	style, err := o.parseStyle()
+	if err != nil {
+		return err
+	}
+	indent, err := parseIndent(o.indent)
+	if err != nil {
+		return err
+	}
+	globalCustomProperties, err := parseCustomProperties(o.globalCustomProperties)
+	if err != nil {
+		return err
+	}
+	j.Opts = cssbuild.TransformOpts{
+		CamelCaseJSKeys:  o.camelCaseJSKeys,
+		Style:            style,
+		Indent:           indent,
+		PreserveComments: o.preserveComments,
+		Strict:           o.strict,
+
+		ScopeCustomProperties:  o.scopeCustomProperties,
+		GlobalCustomProperties: globalCustomProperties,
+	}
+	j.Check = o.check
+	if o.config != nil {
+		j.ConfigPath = o.config.path
+	}
+	if o.stableSuffix {
+		key := j.JSModuleName
+		if key == "" {
+			key = filepath.ToSlash(j.InputPath)
 		}
-		defer js.Close()
+		j.Suffix = cssbuild.StableSuffix(key)
+	}
+	return nil
+}
 
-		tsDeclarationPath := *tsDeclarationPath
-		if tsDeclarationPath == "" {
-			tsDeclarationPath = strings.TrimSuffix(jsPath, ".js") + ".d.ts"
+// parseCustomProperties parses the comma-separated list of custom properties
+// given by the -global_custom_properties flag.
+func parseCustomProperties(value string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
 		}
-		tsd, err = os.Create(tsDeclarationPath)
-		if err != nil {
-			fatal(err)
+		if !strings.HasPrefix(name, "--") {
+			return nil, fmt.Errorf("invalid custom property %q (`-global_custom_properties` flag); custom properties start with \"--\"", name)
 		}
-		defer tsd.Close()
-	} else {
-		ts, err = os.Create(*tsPath)
-		if err != nil {
-			fatal(err)
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// parseStyle returns the output style given by the -style and -minify flags.
+func (o *options) parseStyle() (cssbuild.Style, error) {
+	style := cssbuild.Style(o.style)
+	switch style {
+	case cssbuild.StyleExpanded, cssbuild.StyleCompact, cssbuild.StyleMinified, cssbuild.StylePassthrough:
+	default:
+		return "", fmt.Errorf("unknown style %q (`-style` flag)", o.style)
+	}
+	if o.minify {
+		if style != cssbuild.StyleExpanded && style != cssbuild.StyleMinified {
+			return "", fmt.Errorf("cannot specify both `-minify` flag and `-style=%s` flag", o.style)
 		}
-		defer ts.Close()
+		style = cssbuild.StyleMinified
 	}
+	return style, nil
+}
 
-	opts := &cssbuild.TransformOpts{
-		JSWriter:            js,
-		TSDeclarationWriter: tsd,
-		TSWriter:            ts,
-		JSModuleName:        *jsModuleName,
-		CamelCaseJSKeys:     *camelCaseJSKeys,
+// parseIndent returns the indentation given by the value of the -indent
+// flag, which is a number of spaces or "tab".
+func parseIndent(value string) (string, error) {
+	if value == "tab" {
+		return "\t", nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > 8 {
+		return "", fmt.Errorf("invalid indent %q; must be a number of spaces from 0 to 8, or \"tab\" (`-indent` flag)", value)
 	}
-	if err := cssbuild.Transform(in, out, opts); err != nil {
-		fatal(err)
+	if n == 0 {
+		return cssbuild.NoIndent, nil
 	}
+	return strings.Repeat(" ", n), nil
 }
 
-func validateFlags() error {
-	if *inputPath == "" {
+func (o *options) validate() error {
+	if o.watch && o.inputPath == stdioPath {
+		return fmt.Errorf("cannot watch stdin (`-watch` flag)")
+	}
+	if err := o.validateFile(); err != nil {
+		return err
+	}
+	if o.check && o.watch {
+		return fmt.Errorf("cannot specify both `-check` flag and `-watch` flag")
+	}
+	if o.check && o.outputPath == stdioPath {
+		return fmt.Errorf("cannot check CSS written to stdout (`-check` flag)")
+	}
+	if o.outputDir != "" {
+		return o.validateBatch()
+	}
+	if o.flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q; multiple inputs require an output directory (`-out_dir` flag)", o.flags.Args())
+	}
+	if o.inputPath == "" {
 		return fmt.Errorf("missing input CSS module path (`-in` flag)")
 	}
-	if *outputPath == "" {
+	if o.outputPath == "" {
 		return fmt.Errorf("missing output CSS path (`-out` flag)")
 	}
-	if *jsModuleName == "" && *tsPath == "" {
+	if o.usesStdio() && o.jsOutputPath == "" && o.tsDeclarationPath == "" && o.tsPath == "" && o.jsonPath == "" && o.goPath == "" {
+		return fmt.Errorf("mapping outputs (`-js_out`, `-ts_out`, etc.) must be specified explicitly when reading from stdin or writing to stdout")
+	}
+	if o.depfilePath != "" && o.outputPath == stdioPath {
+		return fmt.Errorf("cannot write a depfile (`-depfile` flag) for CSS written to stdout")
+	}
+	if o.goPath != "" && o.outputPath == stdioPath {
+		return fmt.Errorf("cannot embed CSS written to stdout in the Go output (`-go_out` flag)")
+	}
+	writesJS := o.jsOutputPath != "" || o.tsDeclarationPath != "" || o.defaultsMappingOutputs()
+	if o.jsModuleName == "" && writesJS {
 		return fmt.Errorf("missing JS module name (`-js_module_name` flag)")
 	}
-	if *tsDeclarationPath != "" && *tsPath != "" {
-		return fmt.Errorf("cannot specify both `-ts_declaration_out` flag and `-ts_out` flag")
+	if o.goPath != "" && o.goPackage == "" {
+		return fmt.Errorf("missing Go package name (`-go_package` flag)")
 	}
-	if *jsOutputPath != "" && *tsPath != "" {
-		return fmt.Errorf("cannot specify both `-js_out` flag and `-ts_out` flag")
+	return nil
+}
+
+// validateFile validates the flags which may be overridden per file by the
+// config file.
+func (o *options) validateFile() error {
+	if o.check && !o.stableSuffix {
+		return fmt.Errorf("`-check` flag requires `-stable_suffix` flag, since random suffixes are never up to date")
+	}
+	if _, err := o.parseStyle(); err != nil {
+		return err
+	}
+	if _, err := parseIndent(o.indent); err != nil {
+		return err
+	}
+	if _, err := parseCustomProperties(o.globalCustomProperties); err != nil {
+		return err
 	}
 	return nil
 }
 
+func (o *options) validateBatch() error {
+	if o.flags.NArg() == 0 {
+		return fmt.Errorf("missing input CSS module paths or patterns (positional arguments)")
+	}
+	for _, name := range []string{"in", "out", "js_module_name", "js_out", "ts_declaration_out", "ts_out", "json_out", "go_out", "go_package", "depfile"} {
+		if f := o.flags.Lookup(name); f.Value.String() != f.DefValue {
+			return fmt.Errorf("`-%s` flag cannot be used with `-out_dir` flag", name)
+		}
+	}
+	if _, err := parseEmit(o.emit); err != nil {
+		return err
+	}
+	return nil
+}
+
+// usesStdio returns whether the input is read from stdin or the output is
+// written to stdout.
+func (o *options) usesStdio() bool {
+	return o.inputPath == stdioPath || o.outputPath == stdioPath
+}
+
+// writesStdout returns whether any output, including the mapping outputs, is
+// written to stdout.
+func (o *options) writesStdout() bool {
+	for _, path := range []string{o.outputPath, o.jsOutputPath, o.tsDeclarationPath, o.tsPath, o.jsonPath, o.goPath, o.depfilePath} {
+		if path == stdioPath {
+			return true
+		}
+	}
+	return false
+}
+
 func fatal(err error) {
 	io.WriteString(os.Stderr, "fatal: "+err.Error()+"\n")
 	os.Exit(1)
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// fileStamp identifies a version of a file's contents.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// jobStamp identifies the versions of the files which a job's outputs depend
+// on.
+type jobStamp struct {
+	input, config fileStamp
+	// references are the stamps of the local files referenced by the input
+	// when the job last ran, such as the stylesheets it imports or composes
+	// from.
+	references []fileStamp
+}
+
+func (s jobStamp) equal(other jobStamp) bool {
+	if s.input != other.input || s.config != other.config || len(s.references) != len(other.references) {
+		return false
+	}
+	for i, r := range s.references {
+		if r != other.references[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// watch runs the jobs returned by listJobs, then keeps polling their inputs,
+// config files and the local files referenced by their inputs, and re-runs
+// only the jobs for which any of these changed. listJobs is called on every
+// poll, so that inputs added to a batch are picked up. It returns once stop
+// is closed, which may be nil to keep watching forever.
+func watch(listJobs func() ([]*job, error), o *options, stderr io.Writer, stop <-chan struct{}) {
+	stamps := map[string]jobStamp{}
+	// references holds the paths of the local files referenced by each
+	// input when its job last ran.
+	references := map[string][]string{}
+	lastListErr := ""
+	for ; ; time.Sleep(o.watchInterval) {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		jobs, err := listJobs()
+		if err != nil {
+			if err.Error() != lastListErr {
+				fmt.Fprintf(stderr, "error: %s\n", err)
+				lastListErr = err.Error()
+			}
+			continue
+		}
+		lastListErr = ""
+
+		var changed []*job
+		seen := map[string]bool{}
+		for _, j := range jobs {
+			seen[j.InputPath] = true
+			info, err := os.Stat(j.InputPath)
+			if err != nil {
+				// Report missing inputs once, rather than on every poll.
+				if prev, ok := stamps[j.InputPath]; !ok || prev.input != (fileStamp{}) {
+					fmt.Fprintf(stderr, "error: %s\n", err)
+				}
+				stamps[j.InputPath] = jobStamp{}
+				continue
+			}
+			stamp := jobStamp{
+				input:      fileStamp{modTime: info.ModTime(), size: info.Size()},
+				config:     statStamp(j.ConfigPath),
+				references: statStamps(references[j.InputPath]),
+			}
+			if prev, ok := stamps[j.InputPath]; ok && prev.equal(stamp) {
+				continue
+			}
+			stamps[j.InputPath] = stamp
+			changed = append(changed, j)
+		}
+		for path := range stamps {
+			if !seen[path] {
+				delete(stamps, path)
+				delete(references, path)
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		start := time.Now()
+		errs := runJobs(changed, o.parallelism)
+		failed := 0
+		for i, err := range errs {
+			j := changed[i]
+			j.writeWarnings(stderr)
+			if err != nil {
+				fmt.Fprintf(stderr, "error: %s: %s\n", j.InputPath, err)
+				failed++
+				continue
+			}
+			// Watch the files which the input references as of this run,
+			// keeping the previous ones if it failed.
+			references[j.InputPath] = j.References
+			stamp := stamps[j.InputPath]
+			stamp.references = statStamps(j.References)
+			stamps[j.InputPath] = stamp
+		}
+		summary := fmt.Sprintf("[%s] rebuilt %d file(s) in %s", start.Format("15:04:05"), len(changed), time.Since(start).Round(time.Millisecond))
+		if len(changed) == 1 {
+			summary = fmt.Sprintf("[%s] rebuilt %s in %s", start.Format("15:04:05"), changed[0].InputPath, time.Since(start).Round(time.Millisecond))
+		}
+		if failed > 0 {
+			summary += fmt.Sprintf(" (%d failed)", failed)
+		}
+		fmt.Fprintln(stderr, summary)
+	}
+}
+
+// statStamp returns the stamp of the file at path, or the zero stamp if path
+// is empty or the file can't be read.
+func statStamp(path string) fileStamp {
+	if path == "" {
+		return fileStamp{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// statStamps returns the stamps of the files at the given paths.
+func statStamps(paths []string) []fileStamp {
+	var stamps []fileStamp
+	for _, p := range paths {
+		stamps = append(stamps, statStamp(p))
+	}
+	return stamps
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer which may be written and read concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.module.css")
+	base := filepath.Join(dir, "base.module.css")
+	unrelated := filepath.Join(dir, "unrelated.css")
+	for path, contents := range map[string]string{
+		in:        "@import \"base.module.css\";\n.foo { composes: bar from \"./base.module.css\"; }\n",
+		base:      ".bar { color: red; }\n",
+		unrelated: ".baz { color: red; }\n",
+	} {
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listJobs := func() ([]*job, error) {
+		return []*job{{InputPath: in, OutputPath: filepath.Join(dir, "a.css")}}, nil
+	}
+	o := newOptions(io.Discard)
+	o.watchInterval = 5 * time.Millisecond
+	var stderr lockedBuffer
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		watch(listJobs, o, &stderr, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	waitForRebuilds := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(o.watchInterval) {
+			if strings.Count(stderr.String(), "rebuilt") >= n {
+				break
+			}
+		}
+		if count := strings.Count(stderr.String(), "rebuilt"); count != n {
+			t.Fatalf("expected %d rebuild(s), got %d:\n%s", n, count, stderr.String())
+		}
+	}
+	waitForRebuilds(1)
+
+	// Sizes are changed along with the contents, so that the changes are
+	// detected regardless of the resolution of modification times.
+	if err := os.WriteFile(base, []byte(".bar { color: blue; }\n.qux {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForRebuilds(2)
+
+	if err := os.WriteFile(unrelated, []byte(".baz { color: blue; }\n.qux {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * o.watchInterval)
+	waitForRebuilds(2)
+
+	if err := os.WriteFile(in, []byte(".foo { color: red; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForRebuilds(3)
+	output, err := os.ReadFile(filepath.Join(dir, "a.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), "color: red") || strings.Contains(string(output), "@import") {
+		t.Fatalf("expected the output to be rebuilt from the new input, got:\n%s", output)
+	}
+
+	// The input no longer references base.module.css, so changing it doesn't
+	// trigger a rebuild anymore.
+	if err := os.WriteFile(base, []byte(".bar {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * o.watchInterval)
+	waitForRebuilds(3)
+}
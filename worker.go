@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// workRequest is a request in Bazel's JSON persistent worker protocol.
+type workRequest struct {
+	Arguments  []string    `json:"arguments"`
+	Inputs     []workInput `json:"inputs"`
+	RequestID  int         `json:"requestId"`
+	Cancel     bool        `json:"cancel"`
+	Verbosity  int         `json:"verbosity"`
+	SandboxDir string      `json:"sandboxDir"`
+}
+
+// workInput is an input file of a work request, along with its digest.
+type workInput struct {
+	Path   string `json:"path"`
+	Digest string `json:"digest"`
+}
+
+// workResponse is a response in Bazel's JSON persistent worker protocol.
+type workResponse struct {
+	ExitCode  int    `json:"exitCode"`
+	Output    string `json:"output"`
+	RequestID int    `json:"requestId"`
+}
+
+// runWorker reads work requests from r until EOF, runs each of them as if
+// cssbuild was invoked with startupArgs followed by the request's arguments,
+// and writes a response for each of them to w.
+func runWorker(r io.Reader, w io.Writer, startupArgs []string) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	// The diagnostics buffer is reused across requests.
+	var output bytes.Buffer
+	for {
+		req := &workRequest{}
+		if err := dec.Decode(req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read work request: %s", err)
+		}
+		if req.Cancel {
+			// Requests are handled one at a time, so the request to cancel has
+			// always been responded to already.
+			continue
+		}
+
+		output.Reset()
+		res := &workResponse{RequestID: req.RequestID}
+		args, err := expandParamFiles(req.Arguments)
+		if err == nil {
+			args = append(append([]string{}, startupArgs...), args...)
+			err = checkWorkerArgs(args)
+		}
+		if err != nil {
+			fmt.Fprintf(&output, "fatal: %s\n", err)
+			res.ExitCode = 1
+		} else {
+			res.ExitCode = run(args, &output)
+		}
+		res.Output = output.String()
+
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// checkWorkerArgs returns an error if the given arguments can't be run by a
+// worker, which uses stdin and stdout for the worker protocol and must
+// respond to every request.
+func checkWorkerArgs(args []string) error {
+	o := newOptions(io.Discard)
+	if err := o.parse(args); err != nil {
+		return err
+	}
+	if o.usesStdio() || o.writesStdout() {
+		return fmt.Errorf("stdin and stdout cannot be used by work requests")
+	}
+	if o.watch {
+		return fmt.Errorf("`-watch` flag cannot be used by work requests")
+	}
+	return nil
+}
+
+// expandParamFiles replaces "@path" arguments with the contents of the file
+// at that path, which must contain one argument per line, as written by
+// Bazel's "multiline" param file format.
+func expandParamFiles(args []string) ([]string, error) {
+	var out []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			out = append(out, arg)
+			continue
+		}
+		b, err := os.ReadFile(arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line != "" {
+				out = append(out, line)
+			}
+		}
+	}
+	return out, nil
+}
+
+// withoutWorkerFlag returns the given arguments without the flag that
+// starts the persistent worker.
+func withoutWorkerFlag(args []string) []string {
+	var out []string
+	for _, arg := range args {
+		switch strings.TrimLeft(arg, "-") {
+		case "persistent_worker", "persistent_worker=true":
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
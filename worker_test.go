@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorker(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.module.css")
+	if err := os.WriteFile(in, []byte(".foo { color: red; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	paramFile := filepath.Join(dir, "args.params")
+	params := strings.Join([]string{"-in", in, "-out", filepath.Join(dir, "b.css")}, "\n") + "\n"
+	if err := os.WriteFile(paramFile, []byte(params), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests bytes.Buffer
+	enc := json.NewEncoder(&requests)
+	for _, req := range []*workRequest{
+		{RequestID: 1, Arguments: []string{"-in", in, "-out", filepath.Join(dir, "a.css")}},
+		{RequestID: 2, Arguments: []string{"@" + paramFile}},
+		{RequestID: 3, Arguments: []string{"-in", filepath.Join(dir, "missing.css"), "-out", filepath.Join(dir, "c.css")}},
+		{RequestID: 3, Cancel: true},
+		{RequestID: 4, Arguments: []string{"-in", "-", "-out", filepath.Join(dir, "d.css")}},
+		{RequestID: 5, Arguments: []string{"-in", in, "-out", filepath.Join(dir, "e.css"), "-json_out", "-"}},
+		{RequestID: 6, Arguments: []string{"-in", in, "-out", filepath.Join(dir, "f.css"), "-ts_declaration_out", "-"}},
+	} {
+		if err := enc.Encode(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var responses bytes.Buffer
+	if err := runWorker(&requests, &responses, []string{"-json_out", filepath.Join(dir, "out.json"), "-ts_out", filepath.Join(dir, "out.ts")}); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(&responses)
+	var actual []*workResponse
+	for {
+		res := &workResponse{}
+		if err := dec.Decode(res); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, res)
+	}
+	if len(actual) != 6 {
+		t.Fatalf("expected 6 responses, got %d", len(actual))
+	}
+	for i, expected := range []struct {
+		requestID int
+		exitCode  int
+		output    string
+	}{
+		{1, 0, ""},
+		{2, 0, ""},
+		{3, 1, "no such file or directory"},
+		{4, 1, "stdin and stdout cannot be used"},
+		{5, 1, "stdin and stdout cannot be used"},
+		{6, 1, "stdin and stdout cannot be used"},
+	} {
+		res := actual[i]
+		if res.RequestID != expected.requestID || res.ExitCode != expected.exitCode || !strings.Contains(res.Output, expected.output) {
+			t.Fatalf("response %d: expected request ID %d, exit code %d and output containing %q, got %+v", i, expected.requestID, expected.exitCode, expected.output, res)
+		}
+	}
+	for _, name := range []string{"a.css", "b.css", "out.json", "out.ts"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}